@@ -0,0 +1,342 @@
+package vklongpoll
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Префикс файлов архива по умолчанию
+var DefaultArchivePrefix = "updates"
+
+// Формат времени в именах файлов архива (сортируется как строка)
+const archiveTimeFormat = "20060102T150405.000000000"
+
+// Ошибка записи в закрытый архив
+var ErrArchiveClosed = errors.New("archive closed")
+
+// Параметры архива событий
+type ArchiveOptions struct {
+	Dir          string        // Папка архива
+	Prefix       string        // Префикс имен файлов ("" - DefaultArchivePrefix)
+	Source       string        // Значение поля source ("" - Batch.Source)
+	MaxSize      int64         // Размер файла, после которого начинается новый файл (0 - без ограничения)
+	MaxAge       time.Duration // Время, после которого начинается новый файл (0 - без ограничения)
+	MaxSegments  int           // Сколько сжатых файлов хранить (0 - все)
+	RetentionAge time.Duration // Сколько хранить сжатые файлы (0 - без ограничения)
+}
+
+// Строка архива
+type ArchiveRecord struct {
+	ReceivedAt time.Time       `json:"received_at"` // Время получения события
+	Source     string          `json:"source"`      // Источник событий
+	Ts         int64           `json:"ts"`          // Значение ts, с которым было получено событие
+	Update     json.RawMessage `json:"update"`      // Событие без изменений
+}
+
+// Архив полученных событий в JSONL файлах
+// Каждое событие записывается отдельной строкой ArchiveRecord, файл синхронизируется с диском (fsync)
+// до того, как Recv продвинет ts. Файлы ротируются по размеру и времени, закрытые файлы сжимаются gzip
+// в фоне, старые сжатые файлы удаляются по MaxSegments и RetentionAge
+type Archive struct {
+	opts        ArchiveOptions
+	file        *os.File
+	size        int64
+	openedAt    time.Time
+	ageTimer    *time.Timer // Ротирует файл по MaxAge, даже если в архив ничего не пишется
+	closed      bool
+	compress    sync.WaitGroup
+	compressErr error // Последняя ошибка фонового сжатия или ротации
+	mx          sync.Mutex
+}
+
+// Открывает архив в папке opts.Dir
+// Файлы, оставшиеся несжатыми после предыдущего запуска, сжимаются
+func NewArchive(opts ArchiveOptions) (*Archive, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultArchivePrefix
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{opts: opts}
+
+	segments, err := a.segments(".jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range segments {
+		a.compressSegment(segment)
+	}
+
+	return a, nil
+}
+
+// Записывает пачку событий в архив, используется как UpdatesSink:
+//
+//	lp.Recv(ctx, vklongpoll.WithSink(archive.Write))
+func (a *Archive) Write(ctx context.Context, batch *Batch) error {
+	source := a.opts.Source
+	if source == "" {
+		source = batch.Source
+	}
+
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	// Иначе <, > и & внутри событий заменяются на \u003c и т.д.
+	encoder.SetEscapeHTML(false)
+	for _, update := range batch.Updates {
+		err := encoder.Encode(ArchiveRecord{
+			ReceivedAt: batch.ReceivedAt,
+			Source:     source,
+			Ts:         batch.PrevTs,
+			Update:     json.RawMessage(update),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if a.closed {
+		return ErrArchiveClosed
+	}
+
+	if a.file != nil && a.shouldRotate(time.Now()) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(buf.Bytes())
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	return a.file.Sync()
+}
+
+// Закрывает текущий файл и начинает новый при следующей записи
+func (a *Archive) Rotate() error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	return a.rotate()
+}
+
+// Закрывает архив: сжимает текущий файл и ждет завершения фонового сжатия
+// Возвращает последнюю ошибку сжатия или ротации, если она была
+func (a *Archive) Close() error {
+	a.mx.Lock()
+	a.closed = true
+	err := a.rotate()
+	a.mx.Unlock()
+
+	a.compress.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	return a.compressErr
+}
+
+// Проверяет, нужно ли начать новый файл, вызывается под блокировкой
+func (a *Archive) shouldRotate(now time.Time) bool {
+	if a.opts.MaxSize > 0 && a.size >= a.opts.MaxSize {
+		return true
+	}
+	return a.opts.MaxAge > 0 && now.Sub(a.openedAt) >= a.opts.MaxAge
+}
+
+// Открывает новый файл, вызывается под блокировкой
+func (a *Archive) open() error {
+	now := time.Now()
+	name := filepath.Join(a.opts.Dir, a.opts.Prefix+"-"+now.UTC().Format(archiveTimeFormat)+".jsonl")
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	a.file = file
+	a.size = 0
+	a.openedAt = now
+
+	if a.opts.MaxAge > 0 {
+		a.ageTimer = time.AfterFunc(a.opts.MaxAge, a.rotateExpired)
+	}
+
+	return nil
+}
+
+// Ротирует файл, если он открыт дольше MaxAge
+func (a *Archive) rotateExpired() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	// Таймер мог сработать для уже закрытого файла
+	if a.closed || a.file == nil || !a.shouldRotate(time.Now()) {
+		return
+	}
+
+	if err := a.rotate(); err != nil {
+		a.compressErr = err
+	}
+}
+
+// Закрывает текущий файл и сжимает его в фоне, вызывается под блокировкой
+func (a *Archive) rotate() error {
+	if a.file == nil {
+		return nil
+	}
+
+	if a.ageTimer != nil {
+		a.ageTimer.Stop()
+		a.ageTimer = nil
+	}
+
+	name := a.file.Name()
+	err := a.file.Close()
+	a.file = nil
+	if err != nil {
+		return err
+	}
+
+	a.compressSegment(name)
+	return nil
+}
+
+// Сжимает файл в фоне и применяет политику хранения
+func (a *Archive) compressSegment(name string) {
+	a.compress.Add(1)
+	go func() {
+		defer a.compress.Done()
+
+		err := compressFile(name)
+		if err == nil {
+			err = a.applyRetention(time.Now())
+		}
+
+		if err != nil {
+			a.mx.Lock()
+			a.compressErr = err
+			a.mx.Unlock()
+		}
+	}()
+}
+
+// Удаляет сжатые файлы сверх MaxSegments и старше RetentionAge
+func (a *Archive) applyRetention(now time.Time) error {
+	if a.opts.MaxSegments <= 0 && a.opts.RetentionAge <= 0 {
+		return nil
+	}
+
+	segments, err := a.segments(".jsonl.gz")
+	if err != nil {
+		return err
+	}
+
+	for i, segment := range segments {
+		remove := a.opts.MaxSegments > 0 && len(segments)-i > a.opts.MaxSegments
+
+		if !remove && a.opts.RetentionAge > 0 {
+			info, err := os.Stat(segment)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			remove = err == nil && now.Sub(info.ModTime()) > a.opts.RetentionAge
+		}
+
+		if remove {
+			if err := os.Remove(segment); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Возвращает файлы архива с расширением ext от старых к новым
+func (a *Archive) segments(ext string) ([]string, error) {
+	entries, err := os.ReadDir(a.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, a.opts.Prefix+"-") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		segments = append(segments, filepath.Join(a.opts.Dir, name))
+	}
+
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// Сжимает файл name в name.gz через временный файл и удаляет исходный
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), name+".gz")
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Remove(name)
+}
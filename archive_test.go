@@ -0,0 +1,183 @@
+package vklongpoll_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+// Читает строки сжатого файла архива
+func readArchiveSegment(t *testing.T, name string) []vklongpoll.ArchiveRecord {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []vklongpoll.ArchiveRecord{}
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		record := vklongpoll.ArchiveRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	// Файл, который не успели сжать до перезапуска
+	leftover := filepath.Join(dir, "updates-20200101T000000.000000000.jsonl")
+	if err := os.WriteFile(leftover, []byte(`{"ts": 1, "update": {}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := vklongpoll.NewArchive(vklongpoll.ArchiveOptions{Dir: dir, Source: "test", MaxSize: 1, MaxSegments: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receivedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for ts := int64(1); ts <= 4; ts++ {
+		err := archive.Write(context.Background(), &vklongpoll.Batch{
+			ReceivedAt: receivedAt,
+			PrevTs:     ts,
+			Ts:         ts + 1,
+			Updates:    []vklongpoll.Update{[]byte(`{"type": "message_new"}`), []byte(`[4, 1, "<b>&</b>"]`)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Write(context.Background(), &vklongpoll.Batch{}); err != vklongpoll.ErrArchiveClosed {
+		t.Errorf("expected ErrArchiveClosed but got %v", err)
+	}
+
+	if _, err := os.Stat(leftover + ".gz"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected leftover segment to be removed by retention: %v", err)
+	}
+
+	plain, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(plain) != 0 {
+		t.Errorf("expected all segments to be compressed but got %v", plain)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "updates-*.jsonl.gz"))
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments but got %v", segments)
+	}
+
+	// Каждая пачка в своем файле (MaxSize 1), остались последние три
+	for i, segment := range segments {
+		records := readArchiveSegment(t, segment)
+		if len(records) != 2 {
+			t.Fatalf("expected 2 records but got %+v", records)
+		}
+
+		record := records[1]
+		if record.Ts != int64(i+2) || record.Source != "test" || !record.ReceivedAt.Equal(receivedAt) || string(record.Update) != `[4,1,"<b>&</b>"]` {
+			t.Errorf("unexpected record: %+v, update %s", record, record.Update)
+		}
+	}
+}
+
+func TestArchiveMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	archive, err := vklongpoll.NewArchive(vklongpoll.ArchiveOptions{Dir: dir, MaxAge: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	err = archive.Write(context.Background(), &vklongpoll.Batch{Updates: []vklongpoll.Update{[]byte(`[4, 1, 2]`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Новых событий нет, но файл все равно ротируется и сжимается
+	deadline := time.Now().Add(time.Second)
+	for {
+		segments, _ := filepath.Glob(filepath.Join(dir, "updates-*.jsonl.gz"))
+		if len(segments) == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("idle archive was not rotated: %v", segments)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSink(t *testing.T) {
+	serverUpdater, closeServers := startLongPollServers(t, longPollBatch(t, 3))
+	defer closeServers()
+
+	lp := vklongpoll.New()
+	sinkErr := errors.New("disk is full")
+
+	_, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithSink(func(ctx context.Context, batch *vklongpoll.Batch) error {
+		return sinkErr
+	}))
+
+	if !errors.Is(err, sinkErr) {
+		t.Errorf("expected sink error but got %v", err)
+	}
+
+	if lp.Ts != 1 {
+		t.Errorf("ts advanced after sink error: %d", lp.Ts)
+	}
+
+	batches := []*vklongpoll.Batch{}
+	filter := vklongpoll.MustCompileFilter(`event_id == "2"`)
+	updates, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithFilter(filter), vklongpoll.WithSink(func(ctx context.Context, batch *vklongpoll.Batch) error {
+		batches = append(batches, batch)
+		return nil
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updates) != 1 || lp.Ts != 2 {
+		t.Errorf("unexpected recv result: %d updates, ts %d", len(updates), lp.Ts)
+	}
+
+	// Получатель видит все события, а не только прошедшие фильтр
+	if len(batches) != 1 || len(batches[0].Updates) != 3 || batches[0].PrevTs != 1 || batches[0].Ts != 2 {
+		t.Fatalf("unexpected batches: %+v", batches)
+	}
+
+	// Фильтр не меняет события, которые получатель сохранил
+	if !bytes.Contains(batches[0].Updates[0], []byte(`"event_id":"0"`)) {
+		t.Errorf("sink updates changed by filter: %s", batches[0].Updates[0])
+	}
+}
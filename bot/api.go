@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"errors"
+
+	"github.com/buger/jsonparser"
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+)
+
+// Ошибка вызова метода API, если у маршрутизатора не задан API
+var ErrNoAPI = errors.New("router api is not set")
+
+// Доступ к VK API от имени сообщества
+// Используйте тот же executor, что и для UniversalServerUpdater, чтобы запросы шли через общие прокси и обработчики
+type API struct {
+	Executor  *executor.Executor
+	Token     string    // Токен сообщества
+	Sender    *Sender   // Очередь для ответов обработчиков (nil - запросы выполняются сразу)
+	randomIDs randomIDs // Недавно выданные random_id
+}
+
+// Создает API с очередью Sender, через которую обработчики отправляют ответы
+func NewAPI(exec *executor.Executor, token string) *API {
+	return &API{Executor: exec, Token: token, Sender: NewSender(exec, SenderOptions{})}
+}
+
+// Вызывает метод API через очередь Sender (если она задана) и возвращает поле response ответа
+func (a *API) Do(ctx context.Context, method string, params map[string]string) ([]byte, error) {
+	if a != nil && a.Sender != nil {
+		return a.Sender.Call(ctx, a.Token, method, params)
+	}
+	return a.Call(ctx, method, params)
+}
+
+// Вызывает метод API сразу, минуя очередь, и возвращает поле response ответа
+func (a *API) Call(ctx context.Context, method string, params map[string]string) ([]byte, error) {
+	if a == nil {
+		return nil, ErrNoAPI
+	}
+
+	req := request.New()
+	req.Method(method)
+	req.GetParams().AccessToken(a.Token)
+	for key, value := range params {
+		req.GetParams().Set(key, value)
+	}
+
+	res, err := a.Executor.DoRequestCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	value, _, _, err := jsonparser.Get(res.Body(), "response")
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,98 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+// Вызов метода тестового API
+type apiCall struct {
+	Method string
+	Params url.Values
+}
+
+// Тестовый API сервер: записывает вызовы и отвечает результатом respond (по умолчанию {"response": 1})
+type apiServer struct {
+	*httptest.Server
+	calls   []apiCall
+	respond func(call apiCall) string
+	mx      sync.Mutex
+}
+
+// Запускает тестовый API сервер и возвращает API, который к нему обращается
+func startAPIServer(t *testing.T) (*bot.API, *apiServer) {
+	server := &apiServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+
+		call := apiCall{Method: strings.TrimPrefix(r.URL.Path, "/"), Params: r.Form}
+
+		server.mx.Lock()
+		server.calls = append(server.calls, call)
+		respond := server.respond
+		server.mx.Unlock()
+
+		if respond != nil {
+			w.Write([]byte(respond(call)))
+			return
+		}
+		w.Write([]byte(`{"response": 1}`))
+	}))
+
+	t.Cleanup(server.Close)
+
+	request.DefaultBaseRequestUrl = server.URL + "/"
+	return bot.NewAPI(executor.New(), "group_token"), server
+}
+
+// Возвращает копию списка вызовов
+func (s *apiServer) Calls() []apiCall {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return append([]apiCall{}, s.calls...)
+}
+
+func TestAPICall(t *testing.T) {
+	api, server := startAPIServer(t)
+	server.respond = func(call apiCall) string {
+		if call.Params.Get("user_ids") == "" {
+			return `{"error": {"error_code": 100, "error_msg": "One of the parameters specified was missing or invalid"}}`
+		}
+		return `{"response": [{"id": 1}]}`
+	}
+
+	res, err := api.Call(context.Background(), "users.get", map[string]string{"user_ids": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res) != `[{"id": 1}]` {
+		t.Errorf("unexpected response: %s", res)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 1 || calls[0].Method != "users.get" || calls[0].Params.Get("access_token") != "group_token" {
+		t.Errorf("unexpected calls: %+v", calls)
+	}
+
+	if _, err := api.Call(context.Background(), "users.get", nil); err == nil {
+		t.Error("expected api error")
+	}
+
+	var noAPI *bot.API
+	if _, err := noAPI.Call(context.Background(), "users.get", nil); err != bot.ErrNoAPI {
+		t.Errorf("expected ErrNoAPI but got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/buger/jsonparser"
+)
+
+// Ошибка DecodePayload для команды, отправленной без кнопки
+var ErrNoPayload = errors.New("command has no payload")
+
+// Параметры распознавания команд
+type CommandOptions struct {
+	Prefixes       []string // Префиксы команд ("/start", "!start")
+	GroupID        int64    // Сообщество бота: упоминания других сообществ в беседах не считаются командами (0 - любое сообщество)
+	PrefixOptional bool     // В личных сообщениях считать командой текст без префикса ("start")
+}
+
+// Возвращает параметры по умолчанию: префиксы "/" и "!"
+func DefaultCommandOptions() CommandOptions {
+	return CommandOptions{
+		Prefixes: []string{"/", "!"},
+	}
+}
+
+// Команда из сообщения
+type Command struct {
+	Name    string   // Имя команды в нижнем регистре
+	Args    []string // Аргументы, строки в кавычках - один аргумент
+	RawArgs string   // Аргументы как есть
+	Prefix  string   // Префикс, с которого началась команда (пустой для упоминания без префикса и кнопки)
+	Mention bool     // Команда отправлена через упоминание бота в беседе
+	Payload []byte   // Payload кнопки, JSON
+}
+
+// Упоминание сообщества: [club123|@bot] или [public123|Бот], после которого может быть запятая или двоеточие
+var mentionRegexp = regexp.MustCompile(`^\[(?:club|public)(\d+)\|[^\]]*\][\s,:]*`)
+
+// Распознает команду в сообщении
+// Кнопка с payload {"command": "start"} - это команда start. В беседах команда может начинаться
+// с упоминания бота, тогда префикс необязателен: "[club1|@bot] help"
+func ParseCommand(message *Message, opts CommandOptions) (*Command, bool) {
+	if len(message.Payload) != 0 {
+		if name, err := jsonparser.GetString(message.Payload, "command"); err == nil && name != "" {
+			// Текст сообщения - это подпись кнопки, а не аргументы
+			return &Command{
+				Name:    strings.ToLower(name),
+				Payload: message.Payload,
+			}, true
+		}
+	}
+
+	text := strings.TrimSpace(message.Text)
+	cmd := &Command{}
+
+	if match := mentionRegexp.FindStringSubmatch(text); match != nil {
+		groupID, _ := strconv.ParseInt(match[1], 10, 64)
+		if opts.GroupID != 0 && groupID != opts.GroupID {
+			return nil, false
+		}
+
+		cmd.Mention = true
+		text = text[len(match[0]):]
+	}
+
+	for _, prefix := range opts.Prefixes {
+		if prefix != "" && strings.HasPrefix(text, prefix) {
+			cmd.Prefix = prefix
+			text = text[len(prefix):]
+			break
+		}
+	}
+
+	if cmd.Prefix == "" && !cmd.Mention && !(opts.PrefixOptional && !message.InChat()) {
+		return nil, false
+	}
+
+	name, rawArgs := text, ""
+	if i := strings.IndexFunc(text, unicode.IsSpace); i != -1 {
+		name, rawArgs = text[:i], strings.TrimSpace(text[i:])
+	}
+
+	if name == "" {
+		return nil, false
+	}
+
+	cmd.Name = strings.ToLower(name)
+	cmd.RawArgs = rawArgs
+	cmd.Args = SplitArgs(rawArgs)
+
+	return cmd, true
+}
+
+// Возвращает аргумент i или пустую строку
+func (c *Command) Arg(i int) string {
+	if i < 0 || i >= len(c.Args) {
+		return ""
+	}
+	return c.Args[i]
+}
+
+// Декодирует payload кнопки в v (через encoding/json)
+func (c *Command) DecodePayload(v interface{}) error {
+	if len(c.Payload) == 0 {
+		return ErrNoPayload
+	}
+	return json.Unmarshal(c.Payload, v)
+}
+
+// Разбивает строку на аргументы по пробелам
+// Строки в двойных или одинарных кавычках - один аргумент, \ экранирует следующий символ.
+// Незакрытая кавычка продолжается до конца строки
+func SplitArgs(s string) []string {
+	args := []string{}
+	arg := strings.Builder{}
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
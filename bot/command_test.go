@@ -0,0 +1,99 @@
+package bot_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := map[string][]string{
+		``:                          {},
+		`one two  three`:            {"one", "two", "three"},
+		`"quoted arg" 'single one'`: {"quoted arg", "single one"},
+		`say "hello \"world\""`:     {"say", `hello "world"`},
+		`path\ with\ spaces ""`:     {"path with spaces", ""},
+		`'C:\dir' "unterminated`:    {`C:\dir`, "unterminated"},
+	}
+
+	for s, want := range cases {
+		if got := bot.SplitArgs(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q but got %q", s, want, got)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	opts := bot.DefaultCommandOptions()
+	opts.GroupID = 1
+
+	cases := []struct {
+		message bot.Message
+		want    *bot.Command
+	}{
+		{
+			bot.Message{PeerID: 123, Text: `/Start "my name" 2`},
+			&bot.Command{Name: "start", Args: []string{"my name", "2"}, RawArgs: `"my name" 2`, Prefix: "/"},
+		},
+		{
+			bot.Message{PeerID: 123, Text: `!help`},
+			&bot.Command{Name: "help", Args: []string{}, Prefix: "!"},
+		},
+		{
+			bot.Message{PeerID: 2000000001, Text: `[club1|@bot], help me`},
+			&bot.Command{Name: "help", Args: []string{"me"}, RawArgs: "me", Mention: true},
+		},
+		{
+			bot.Message{PeerID: 2000000001, Text: `[public1|Бот] /ban 42`},
+			&bot.Command{Name: "ban", Args: []string{"42"}, RawArgs: "42", Prefix: "/", Mention: true},
+		},
+		{
+			bot.Message{PeerID: 123, Text: `Купить`, Payload: []byte(`{"command": "buy", "id": 5}`)},
+			&bot.Command{Name: "buy", Payload: []byte(`{"command": "buy", "id": 5}`)},
+		},
+		{bot.Message{PeerID: 2000000001, Text: `[club2|@other] help`}, nil},
+		{bot.Message{PeerID: 123, Text: `help`}, nil},
+		{bot.Message{PeerID: 123, Text: `/`}, nil},
+	}
+
+	for _, c := range cases {
+		got, ok := bot.ParseCommand(&c.message, opts)
+		if c.want == nil {
+			if ok {
+				t.Errorf("%q: expected no command but got %+v", c.message.Text, got)
+			}
+			continue
+		}
+
+		if !ok || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: expected %+v but got %+v", c.message.Text, c.want, got)
+		}
+	}
+
+	opts.PrefixOptional = true
+	if cmd, ok := bot.ParseCommand(&bot.Message{PeerID: 123, Text: "help"}, opts); !ok || cmd.Name != "help" {
+		t.Errorf("expected command without prefix in private dialog but got %+v", cmd)
+	}
+
+	if _, ok := bot.ParseCommand(&bot.Message{PeerID: 2000000001, Text: "help"}, opts); ok {
+		t.Error("expected no command without prefix in chat")
+	}
+}
+
+func TestCommandPayload(t *testing.T) {
+	cmd, _ := bot.ParseCommand(&bot.Message{PeerID: 123, Payload: []byte(`{"command": "buy", "id": 5}`)}, bot.DefaultCommandOptions())
+
+	payload := struct {
+		ID int `json:"id"`
+	}{}
+
+	if err := cmd.DecodePayload(&payload); err != nil || payload.ID != 5 {
+		t.Errorf("expected payload id 5 but got %d (%v)", payload.ID, err)
+	}
+
+	cmd, _ = bot.ParseCommand(&bot.Message{PeerID: 123, Text: "/buy"}, bot.DefaultCommandOptions())
+	if err := cmd.DecodePayload(&payload); err != bot.ErrNoPayload {
+		t.Errorf("expected ErrNoPayload but got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	"github.com/ciricc/vklongpoll"
+)
+
+// Контекст обработки одного события
+type Context struct {
+	context.Context
+	Update       vklongpoll.Update // Исходное событие
+	Type         string            // Тип события
+	GroupID      int64             // Сообщество, которому пришло событие
+	EventID      string            // Идентификатор события
+	Object       []byte            // Объект события (поле object), JSON
+	Message      *Message          // Сообщение (для message_new, message_reply, message_edit)
+	MessageEvent *MessageEvent     // Нажатие callback-кнопки (для message_event)
+	Command      *Command          // Распознанная команда (если событие обработано как команда)
+	api          *API
+	sends        int         // Количество отправок из обработчика (для random_id)
+	key          *SessionKey // Ключ состояния диалога
+	session      *Session    // Состояние диалога (nil, если сценарии отключены или у события нет диалога)
+	states       StateStore
+}
+
+// Сообщение из события
+type Message struct {
+	ID                    int64
+	ConversationMessageID int64
+	PeerID                int64
+	FromID                int64
+	Date                  int64
+	Text                  string
+	Payload               []byte // Payload кнопки клавиатуры, JSON (nil, если сообщение отправлено не кнопкой)
+}
+
+// Смещение peer_id для бесед
+const chatPeerOffset = 2000000000
+
+// Проверяет, отправлено ли сообщение в беседу
+func (m *Message) InChat() bool {
+	return m.PeerID > chatPeerOffset
+}
+
+// Типы событий, содержащих сообщение
+var messageEvents = map[string]bool{
+	"message_new":   true,
+	"message_reply": true,
+	"message_edit":  true,
+}
+
+// Разбирает общие поля события
+func newContext(ctx context.Context, u vklongpoll.Update, api *API) (*Context, error) {
+	botCtx := &Context{Context: ctx, Update: u, api: api}
+
+	eventType, err := jsonparser.GetString(u, "type")
+	if err != nil {
+		return botCtx, fmt.Errorf("parse event type error: %w", err)
+	}
+
+	botCtx.Type = eventType
+	botCtx.GroupID, _ = jsonparser.GetInt(u, "group_id")
+	botCtx.EventID, _ = jsonparser.GetString(u, "event_id")
+	botCtx.Object, _, _, _ = jsonparser.Get(u, "object")
+
+	if messageEvents[eventType] {
+		message, err := parseMessage(botCtx.Object)
+		if err != nil {
+			return botCtx, fmt.Errorf("parse %s error: %w", eventType, err)
+		}
+		botCtx.Message = message
+	}
+
+	if eventType == "message_event" {
+		event, err := parseMessageEvent(botCtx.Object, api)
+		if err != nil {
+			return botCtx, fmt.Errorf("parse message_event error: %w", err)
+		}
+		botCtx.MessageEvent = event
+	}
+
+	return botCtx, nil
+}
+
+// Разбирает сообщение
+// В message_new начиная с версии API 5.103 сообщение лежит в поле message, а в message_reply и message_edit - в самом объекте
+func parseMessage(object []byte) (*Message, error) {
+	if value, dataType, _, err := jsonparser.Get(object, "message"); err == nil && dataType == jsonparser.Object {
+		object = value
+	}
+
+	message := &Message{}
+	var parseErr error
+
+	jsonparser.EachKey(object, func(idx int, value []byte, dataType jsonparser.ValueType, err error) {
+		if err != nil {
+			parseErr = err
+			return
+		}
+
+		switch idx {
+		case 0:
+			message.ID, _ = jsonparser.ParseInt(value)
+		case 1:
+			message.ConversationMessageID, _ = jsonparser.ParseInt(value)
+		case 2:
+			message.PeerID, _ = jsonparser.ParseInt(value)
+		case 3:
+			message.FromID, _ = jsonparser.ParseInt(value)
+		case 4:
+			message.Date, _ = jsonparser.ParseInt(value)
+		case 5:
+			message.Text, _ = jsonparser.ParseString(value)
+		case 6:
+			// Payload приходит строкой с JSON внутри
+			payload, err := jsonparser.ParseString(value)
+			if err == nil {
+				message.Payload = []byte(payload)
+			}
+		}
+	}, []string{"id"}, []string{"conversation_message_id"}, []string{"peer_id"}, []string{"from_id"}, []string{"date"}, []string{"text"}, []string{"payload"})
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if message.PeerID == 0 {
+		return nil, fmt.Errorf("peer_id not found")
+	}
+
+	return message, nil
+}
@@ -0,0 +1,497 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Состояние диалога в многошаговом сценарии
+type State string
+
+// Начальное состояние (сценарий не запущен)
+const StateNone State = ""
+
+// Команды отмены сценария по умолчанию
+var DefaultCancelCommands = []string{"cancel", "отмена"}
+
+// Ошибка методов состояния для события без диалога
+var ErrNoPeer = errors.New("event has no peer")
+
+// Состояние диалога и промежуточные данные сценария
+type Session struct {
+	State     State                      `json:"state"`
+	Data      map[string]json.RawMessage `json:"data,omitempty"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+}
+
+// Ключ состояния: диалог и пользователь
+// В беседе у каждого участника свой сценарий, в личных сообщениях PeerID и UserID совпадают
+type SessionKey struct {
+	PeerID int64
+	UserID int64
+}
+
+// Возвращает ключ в виде "peer_id:user_id"
+func (k SessionKey) String() string {
+	return strconv.FormatInt(k.PeerID, 10) + ":" + strconv.FormatInt(k.UserID, 10)
+}
+
+// Разбирает ключ из строки "peer_id:user_id" (или "peer_id" для личных сообщений)
+func ParseSessionKey(s string) (SessionKey, error) {
+	peer, user, found := strings.Cut(s, ":")
+
+	peerID, err := strconv.ParseInt(peer, 10, 64)
+	if err != nil {
+		return SessionKey{}, err
+	}
+
+	if !found {
+		return SessionKey{PeerID: peerID, UserID: peerID}, nil
+	}
+
+	userID, err := strconv.ParseInt(user, 10, 64)
+	if err != nil {
+		return SessionKey{}, err
+	}
+
+	return SessionKey{PeerID: peerID, UserID: userID}, nil
+}
+
+// Хранилище состояний диалогов
+type StateStore interface {
+	// Возвращает состояние диалога, nil - если состояния нет
+	Load(ctx context.Context, key SessionKey) (*Session, error)
+	// Сохраняет состояние диалога
+	Save(ctx context.Context, key SessionKey, session *Session) error
+	// Удаляет состояние диалога
+	Delete(ctx context.Context, key SessionKey) error
+	// Возвращает все состояния (для сброса по StateTimeout)
+	Sessions(ctx context.Context) (map[SessionKey]*Session, error)
+}
+
+// Обработчик истечения состояния
+type StateTimeoutHandler func(ctx *Context, expired State) error
+
+// Ключ обработчика состояния
+type stateHandlerKey struct {
+	state     State
+	eventType string
+}
+
+// Добавляет обработчик событий eventType в состоянии state
+// Обработчики состояния вызываются раньше команд и обработчиков типа события
+func (r *Router) State(state State, eventType string, handler HandlerFunc) {
+	r.stateHandlers[stateHandlerKey{state: state, eventType: eventType}] = handler
+}
+
+// Загружает состояние диалога, если сценарии используются
+func (r *Router) loadSession(ctx *Context) error {
+	key, ok := ctx.sessionKey()
+	if r.States == nil || !ok {
+		return nil
+	}
+
+	session, expired, err := r.loadActiveSession(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	ctx.key = &key
+	ctx.session = session
+	ctx.states = r.States
+
+	if expired != nil && r.OnStateTimeout != nil {
+		return r.OnStateTimeout(ctx, expired.State)
+	}
+
+	return nil
+}
+
+// Загружает состояние и сбрасывает его, если сценарий истек по StateTimeout
+// Возвращает текущее состояние и истекшее (nil, если сценарий не истекал)
+func (r *Router) loadActiveSession(ctx context.Context, key SessionKey) (session, expired *Session, err error) {
+	// Событие и ExpireStates не должны сбросить один сценарий дважды
+	r.expireMx.Lock()
+	defer r.expireMx.Unlock()
+
+	session, err = r.States.Load(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session == nil {
+		return &Session{}, nil, nil
+	}
+
+	if !r.isExpired(session, time.Now()) {
+		return session, nil, nil
+	}
+
+	if err := r.States.Delete(ctx, key); err != nil {
+		return nil, nil, err
+	}
+
+	return &Session{}, session, nil
+}
+
+// Проверяет, истек ли сценарий
+func (r *Router) isExpired(session *Session, now time.Time) bool {
+	return session.State != StateNone && r.StateTimeout != 0 && now.Sub(session.UpdatedAt) > r.StateTimeout
+}
+
+// Сбрасывает сценарии, истекшие по StateTimeout, и вызывает для них OnStateTimeout,
+// не дожидаясь следующего события от пользователя. В контексте обработчика нет события,
+// но доступны Send и методы состояния. Ошибки передаются в OnError, без него возвращается первая ошибка
+func (r *Router) ExpireStates(ctx context.Context) error {
+	if r.States == nil || r.StateTimeout == 0 {
+		return nil
+	}
+
+	sessions, err := r.States.Sessions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	now := time.Now()
+
+	for key, session := range sessions {
+		if !r.isExpired(session, now) {
+			continue
+		}
+
+		key := key
+		botCtx := &Context{Context: ctx, api: r.API, key: &key, states: r.States}
+
+		// Состояние могло измениться, пока проверялись остальные диалоги
+		current, expired, err := r.loadActiveSession(ctx, key)
+		if err == nil && expired == nil {
+			continue
+		}
+
+		if err == nil {
+			// random_id отправок из обработчика зависит от сброшенного сценария
+			botCtx.EventID = "state_timeout:" + key.String() + ":" + strconv.FormatInt(expired.UpdatedAt.UnixNano(), 10)
+			botCtx.session = current
+
+			if r.OnStateTimeout != nil {
+				err = r.OnStateTimeout(botCtx, expired.State)
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if r.OnError != nil {
+			r.OnError(botCtx, err)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Вызывает ExpireStates каждые interval (0 - десятая часть StateTimeout), пока ctx не отменен
+// Если OnError не задан, останавливается на первой ошибке
+func (r *Router) RunStateTimeouts(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = r.StateTimeout / 10
+	}
+
+	if interval <= 0 {
+		// Сценарии не сбрасываются по времени
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.ExpireStates(ctx); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Обрабатывает событие в сценарии: отмена или обработчик состояния
+// Возвращает false, если событие нужно обработать как обычно
+func (r *Router) dispatchState(ctx *Context) (bool, error) {
+	if ctx.session == nil || ctx.session.State == StateNone {
+		return false, nil
+	}
+
+	if ctx.Message != nil {
+		if cmd, ok := ParseCommand(ctx.Message, r.Commands); ok && r.isCancelCommand(cmd.Name) {
+			ctx.Command = cmd
+			if err := ctx.Finish(); err != nil {
+				return true, err
+			}
+
+			if r.OnCancel != nil {
+				return true, r.OnCancel(ctx)
+			}
+			return true, nil
+		}
+	}
+
+	handler, ok := r.stateHandlers[stateHandlerKey{state: ctx.session.State, eventType: ctx.Type}]
+	if !ok {
+		return false, nil
+	}
+
+	return true, handler(ctx)
+}
+
+func (r *Router) isCancelCommand(name string) bool {
+	for _, cancel := range r.CancelCommands {
+		if cancel == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Возвращает текущее состояние диалога
+func (c *Context) State() State {
+	if c.session == nil {
+		return StateNone
+	}
+	return c.session.State
+}
+
+// Переводит диалог в состояние state, промежуточные данные сохраняются
+func (c *Context) SetState(state State) error {
+	if c.session == nil {
+		return ErrNoPeer
+	}
+
+	c.session.State = state
+	return c.saveSession()
+}
+
+// Завершает сценарий: сбрасывает состояние и промежуточные данные
+func (c *Context) Finish() error {
+	if c.session == nil {
+		return ErrNoPeer
+	}
+
+	*c.session = Session{}
+	return c.states.Delete(c, *c.key)
+}
+
+// Сохраняет промежуточное значение сценария (в JSON)
+func (c *Context) SetData(key string, value interface{}) error {
+	if c.session == nil {
+		return ErrNoPeer
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if c.session.Data == nil {
+		c.session.Data = map[string]json.RawMessage{}
+	}
+
+	c.session.Data[key] = data
+	return c.saveSession()
+}
+
+// Декодирует промежуточное значение сценария в value, возвращает false, если значения нет
+func (c *Context) Data(key string, value interface{}) (bool, error) {
+	if c.session == nil {
+		return false, ErrNoPeer
+	}
+
+	data, ok := c.session.Data[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, value)
+}
+
+// Сохраняет состояние диалога в хранилище
+func (c *Context) saveSession() error {
+	c.session.UpdatedAt = time.Now()
+	return c.states.Save(c, *c.key, c.session)
+}
+
+// Копирует состояние, чтобы хранилище не зависело от изменений в обработчике
+func copySession(session *Session) *Session {
+	copied := *session
+	if session.Data != nil {
+		copied.Data = make(map[string]json.RawMessage, len(session.Data))
+		for key, value := range session.Data {
+			copied.Data[key] = value
+		}
+	}
+	return &copied
+}
+
+// Хранилище состояний в памяти
+type MemoryStateStore struct {
+	sessions map[SessionKey]*Session
+	mx       sync.Mutex
+}
+
+// Создает хранилище состояний в памяти
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{sessions: map[SessionKey]*Session{}}
+}
+
+func (s *MemoryStateStore) Load(ctx context.Context, key SessionKey) (*Session, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil, nil
+	}
+	return copySession(session), nil
+}
+
+func (s *MemoryStateStore) Save(ctx context.Context, key SessionKey, session *Session) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.sessions[key] = copySession(session)
+	return nil
+}
+
+func (s *MemoryStateStore) Delete(ctx context.Context, key SessionKey) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.sessions, key)
+	return nil
+}
+
+func (s *MemoryStateStore) Sessions(ctx context.Context) (map[SessionKey]*Session, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	sessions := make(map[SessionKey]*Session, len(s.sessions))
+	for key, session := range s.sessions {
+		sessions[key] = copySession(session)
+	}
+	return sessions, nil
+}
+
+// Хранилище состояний в JSON файле
+// Файл перезаписывается целиком при каждом изменении (через временный файл с fsync), поэтому подходит
+// для небольших ботов, которым нужно пережить перезапуск
+type FileStateStore struct {
+	path   string
+	memory *MemoryStateStore
+	mx     sync.Mutex
+}
+
+// Создает хранилище состояний в файле path, загружая сохраненные состояния
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	s := &FileStateStore{path: path, memory: NewMemoryStateStore()}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := map[string]*Session{}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+
+	for key, session := range sessions {
+		sessionKey, err := ParseSessionKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s.memory.sessions[sessionKey] = session
+	}
+
+	return s, nil
+}
+
+func (s *FileStateStore) Load(ctx context.Context, key SessionKey) (*Session, error) {
+	return s.memory.Load(ctx, key)
+}
+
+func (s *FileStateStore) Save(ctx context.Context, key SessionKey, session *Session) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.memory.Save(ctx, key, session)
+	return s.flush()
+}
+
+func (s *FileStateStore) Delete(ctx context.Context, key SessionKey) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.memory.Delete(ctx, key)
+	return s.flush()
+}
+
+func (s *FileStateStore) Sessions(ctx context.Context) (map[SessionKey]*Session, error) {
+	return s.memory.Sessions(ctx)
+}
+
+// Записывает состояния в файл, вызывается под блокировкой
+func (s *FileStateStore) flush() error {
+	s.memory.mx.Lock()
+	sessions := make(map[string]*Session, len(s.memory.sessions))
+	for key, session := range s.memory.sessions {
+		sessions[key.String()] = session
+	}
+	data, err := json.Marshal(sessions)
+	s.memory.mx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		// Без fsync после сбоя переименованный файл может оказаться пустым
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
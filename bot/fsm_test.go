@@ -0,0 +1,246 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+// Возвращает событие message_new
+func messageUpdate(peerID int64, text string) vklongpoll.Update {
+	return chatMessageUpdate(peerID, peerID, text)
+}
+
+// Возвращает событие message_new от пользователя fromID в диалоге peerID
+func chatMessageUpdate(peerID, fromID int64, text string) vklongpoll.Update {
+	return vklongpoll.Update(fmt.Sprintf(`{"type": "message_new", "group_id": 1, "object": {"message": {"id": 1, "peer_id": %d, "from_id": %d, "text": %q}}}`, peerID, fromID, text))
+}
+
+// Сценарий заказа: имя, затем количество
+func orderRouter(t *testing.T, orders *[]string) *bot.Router {
+	router := bot.NewRouter()
+
+	router.Command("order", func(ctx *bot.Context) error {
+		return ctx.SetState("ask_name")
+	})
+
+	router.State("ask_name", "message_new", func(ctx *bot.Context) error {
+		if err := ctx.SetData("name", ctx.Message.Text); err != nil {
+			return err
+		}
+		return ctx.SetState("ask_count")
+	})
+
+	router.State("ask_count", "message_new", func(ctx *bot.Context) error {
+		name := ""
+		if ok, err := ctx.Data("name", &name); !ok || err != nil {
+			t.Errorf("expected name in session data: %v", err)
+		}
+
+		*orders = append(*orders, name+" x"+ctx.Message.Text)
+		return ctx.Finish()
+	})
+
+	return router
+}
+
+func TestFSM(t *testing.T) {
+	orders := []string{}
+	router := orderRouter(t, &orders)
+
+	messages := []string{}
+	router.On("message_new", func(ctx *bot.Context) error {
+		messages = append(messages, ctx.Message.Text)
+		return nil
+	})
+
+	cancelled := 0
+	router.OnCancel = func(ctx *bot.Context) error {
+		cancelled++
+		return nil
+	}
+
+	err := router.Handle(context.Background(), []vklongpoll.Update{
+		messageUpdate(1, "/order"),
+		messageUpdate(2, "/order"),
+		messageUpdate(1, "pizza"),
+		messageUpdate(2, "/cancel"),
+		messageUpdate(2, "hello"),
+		messageUpdate(1, "2"),
+		messageUpdate(1, "thanks"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 1 || orders[0] != "pizza x2" {
+		t.Errorf("unexpected orders: %q", orders)
+	}
+
+	if cancelled != 1 {
+		t.Errorf("expected one cancel but got %d", cancelled)
+	}
+
+	if len(messages) != 2 || messages[0] != "hello" || messages[1] != "thanks" {
+		t.Errorf("expected messages outside of scenario but got %q", messages)
+	}
+}
+
+func TestFSMChat(t *testing.T) {
+	orders := []string{}
+	router := orderRouter(t, &orders)
+
+	// В беседе у каждого участника свой сценарий
+	err := router.Handle(context.Background(), []vklongpoll.Update{
+		chatMessageUpdate(2000000001, 1, "/order"),
+		chatMessageUpdate(2000000001, 2, "/order"),
+		chatMessageUpdate(2000000001, 1, "pizza"),
+		chatMessageUpdate(2000000001, 2, "soup"),
+		chatMessageUpdate(2000000001, 2, "1"),
+		chatMessageUpdate(2000000001, 1, "2"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 2 || orders[0] != "soup x1" || orders[1] != "pizza x2" {
+		t.Errorf("unexpected orders: %q", orders)
+	}
+}
+
+func TestFSMTimeout(t *testing.T) {
+	orders := []string{}
+	router := orderRouter(t, &orders)
+	router.StateTimeout = 50 * time.Millisecond
+
+	var expired bot.State
+	router.OnStateTimeout = func(ctx *bot.Context, state bot.State) error {
+		expired = state
+		if ctx.State() != bot.StateNone {
+			t.Errorf("expected state to be reset but got %q", ctx.State())
+		}
+		return nil
+	}
+
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "/order")})
+	time.Sleep(60 * time.Millisecond)
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "pizza")})
+
+	if expired != "ask_name" {
+		t.Errorf("expected ask_name to expire but got %q", expired)
+	}
+
+	if session, _ := router.States.Load(context.Background(), bot.SessionKey{PeerID: 1, UserID: 1}); session != nil {
+		t.Errorf("expected session to be deleted but got %+v", session)
+	}
+}
+
+func TestFSMExpireStates(t *testing.T) {
+	orders := []string{}
+	router := orderRouter(t, &orders)
+	router.StateTimeout = 50 * time.Millisecond
+
+	expired := make(chan int64, 2)
+	router.OnStateTimeout = func(ctx *bot.Context, state bot.State) error {
+		peerID, ok := ctx.PeerID()
+		if !ok || state != "ask_name" || ctx.State() != bot.StateNone || ctx.Message != nil {
+			t.Errorf("unexpected timeout: peer %d, state %q", peerID, state)
+		}
+		expired <- peerID
+		return nil
+	}
+
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "/order")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- router.RunStateTimeouts(ctx, 10*time.Millisecond)
+	}()
+
+	// Пользователь больше ничего не пишет, но сценарий сбрасывается
+	select {
+	case peerID := <-expired:
+		if peerID != 1 {
+			t.Errorf("expected peer 1 to expire but got %d", peerID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("state did not expire without new events")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context cancellation but got %v", err)
+	}
+
+	if len(expired) != 0 {
+		t.Error("state expired twice")
+	}
+
+	// Следующее сообщение обрабатывается вне сценария
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "pizza")})
+	if len(orders) != 0 {
+		t.Errorf("unexpected orders after timeout: %q", orders)
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "states.json")
+
+	store, err := bot.NewFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders := []string{}
+	router := orderRouter(t, &orders)
+	router.States = store
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "/order"), messageUpdate(1, "pizza")})
+
+	// Перезапуск: новый маршрутизатор и хранилище из того же файла
+	store, err = bot.NewFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router = orderRouter(t, &orders)
+	router.States = store
+	router.Handle(context.Background(), []vklongpoll.Update{messageUpdate(1, "3")})
+
+	if len(orders) != 1 || orders[0] != "pizza x3" {
+		t.Errorf("expected order to continue after restart but got %q", orders)
+	}
+
+	if session, err := store.Load(context.Background(), bot.SessionKey{PeerID: 1, UserID: 1}); session != nil || err != nil {
+		t.Errorf("expected finished session to be deleted but got %+v (%v)", session, err)
+	}
+
+	// Файл со старыми ключами (только peer_id) читается как личные сообщения
+	if err := os.WriteFile(path, []byte(`{"5": {"state": "ask_count"}, "2000000001:7": {"state": "ask_name"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = bot.NewFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := store.Sessions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 || sessions[bot.SessionKey{PeerID: 5, UserID: 5}] == nil || sessions[bot.SessionKey{PeerID: 2000000001, UserID: 7}] == nil {
+		t.Errorf("unexpected sessions: %+v", sessions)
+	}
+}
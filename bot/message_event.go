@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+// Время, за которое обработчик должен ответить на нажатие callback-кнопки,
+// после него отправляется пустой ответ, чтобы у пользователя пропала загрузка
+var DefaultMessageEventDeadline = 3 * time.Second
+
+// Ошибка повторного ответа на событие
+var ErrAlreadyAnswered = errors.New("message event already answered")
+
+// Нажатие callback-кнопки (событие message_event)
+type MessageEvent struct {
+	EventID               string
+	UserID                int64
+	PeerID                int64
+	ConversationMessageID int64
+	Payload               []byte // Payload кнопки, JSON
+	api                   *API
+	answered              bool
+	answering             bool  // Ответ отправляется
+	answerErr             error // Ошибка автоматического ответа
+	mx                    sync.Mutex
+}
+
+// Обработчик нажатия callback-кнопки
+type MessageEventHandler func(ctx *Context, event *MessageEvent) error
+
+// Разбирает событие message_event
+func parseMessageEvent(object []byte, api *API) (*MessageEvent, error) {
+	event := &MessageEvent{api: api}
+
+	var err error
+	if event.EventID, err = jsonparser.GetString(object, "event_id"); err != nil {
+		return nil, err
+	}
+
+	if event.UserID, err = jsonparser.GetInt(object, "user_id"); err != nil {
+		return nil, err
+	}
+
+	if event.PeerID, err = jsonparser.GetInt(object, "peer_id"); err != nil {
+		return nil, err
+	}
+
+	event.ConversationMessageID, _ = jsonparser.GetInt(object, "conversation_message_id")
+	event.Payload, _, _, _ = jsonparser.Get(object, "payload")
+
+	return event, nil
+}
+
+// Декодирует payload кнопки в v (через encoding/json)
+func (e *MessageEvent) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return ErrNoPayload
+	}
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Показывает пользователю всплывающее уведомление
+func (e *MessageEvent) ShowSnackbar(ctx context.Context, text string) error {
+	return e.answer(ctx, map[string]interface{}{"type": "show_snackbar", "text": text})
+}
+
+// Открывает ссылку
+func (e *MessageEvent) OpenLink(ctx context.Context, link string) error {
+	return e.answer(ctx, map[string]interface{}{"type": "open_link", "link": link})
+}
+
+// Открывает VK Mini App (ownerID и hash необязательны)
+func (e *MessageEvent) OpenApp(ctx context.Context, appID, ownerID int64, hash string) error {
+	eventData := map[string]interface{}{"type": "open_app", "app_id": appID}
+	if ownerID != 0 {
+		eventData["owner_id"] = ownerID
+	}
+	if hash != "" {
+		eventData["hash"] = hash
+	}
+	return e.answer(ctx, eventData)
+}
+
+// Отправляет пустой ответ: у пользователя просто пропадает загрузка
+func (e *MessageEvent) Answer(ctx context.Context) error {
+	return e.answer(ctx, nil)
+}
+
+// Проверяет, был ли отправлен ответ (в том числе автоматический)
+func (e *MessageEvent) Answered() bool {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	return e.answered
+}
+
+// Отправляет ответ через messages.sendMessageEventAnswer, отвечать можно только один раз
+// Пока ответ отправляется, остальные ответы возвращают ErrAlreadyAnswered. Если отправить не удалось, можно ответить еще раз
+func (e *MessageEvent) answer(ctx context.Context, eventData map[string]interface{}) (err error) {
+	e.mx.Lock()
+	if e.answered || e.answering {
+		e.mx.Unlock()
+		return ErrAlreadyAnswered
+	}
+	e.answering = true
+	e.mx.Unlock()
+
+	// Запрос выполняется без блокировки, чтобы не задерживать автоматический ответ
+	defer func() {
+		e.mx.Lock()
+		e.answering = false
+		e.answered = err == nil
+		e.mx.Unlock()
+	}()
+
+	params := map[string]string{
+		"event_id": e.EventID,
+		"user_id":  strconv.FormatInt(e.UserID, 10),
+		"peer_id":  strconv.FormatInt(e.PeerID, 10),
+	}
+
+	if eventData != nil {
+		data, err := json.Marshal(eventData)
+		if err != nil {
+			return err
+		}
+		params["event_data"] = string(data)
+	}
+
+	_, err = e.api.Do(ctx, "messages.sendMessageEventAnswer", params)
+	return err
+}
+
+// Добавляет обработчик нажатий callback-кнопок
+// Если обработчик не ответил за MessageEventDeadline или завершился без ответа, отправляется пустой ответ
+func (r *Router) OnMessageEvent(handler MessageEventHandler) {
+	r.On("message_event", func(ctx *Context) error {
+		event := ctx.MessageEvent
+
+		deadline := r.MessageEventDeadline
+		if deadline == 0 {
+			deadline = DefaultMessageEventDeadline
+		}
+
+		autoAnswered := make(chan struct{})
+		timer := time.AfterFunc(deadline, func() {
+			defer close(autoAnswered)
+
+			answerCtx, cancel := context.WithTimeout(context.Background(), deadline)
+			defer cancel()
+
+			if err := event.Answer(answerCtx); err != nil && err != ErrAlreadyAnswered {
+				event.mx.Lock()
+				event.answerErr = err
+				event.mx.Unlock()
+			}
+		})
+
+		err := handler(ctx, event)
+
+		if timer.Stop() {
+			if answerErr := event.Answer(ctx); err == nil && answerErr != ErrAlreadyAnswered {
+				err = answerErr
+			}
+		} else {
+			// Таймер уже сработал, ждем автоматический ответ
+			<-autoAnswered
+		}
+
+		event.mx.Lock()
+		defer event.mx.Unlock()
+
+		if err == nil {
+			err = event.answerErr
+		}
+
+		return err
+	})
+}
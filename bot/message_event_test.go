@@ -0,0 +1,155 @@
+package bot_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+// Возвращает событие message_event
+func messageEventUpdate(eventID string) vklongpoll.Update {
+	return vklongpoll.Update(`{"type": "message_event", "group_id": 1, "object": {"event_id": "` + eventID + `", "user_id": 123, "peer_id": 123, "conversation_message_id": 7, "payload": {"button": "like"}}}`)
+}
+
+func TestMessageEvent(t *testing.T) {
+	api, server := startAPIServer(t)
+
+	router := bot.NewRouter()
+	router.API = api
+	router.MessageEventDeadline = 100 * time.Millisecond
+
+	router.OnMessageEvent(func(ctx *bot.Context, event *bot.MessageEvent) error {
+		payload := struct {
+			Button string `json:"button"`
+		}{}
+
+		if err := event.DecodePayload(&payload); err != nil {
+			return err
+		}
+
+		switch event.EventID {
+		case "snackbar":
+			if err := event.ShowSnackbar(ctx, "liked "+payload.Button); err != nil {
+				return err
+			}
+
+			if err := event.OpenLink(ctx, "https://vk.com"); err != bot.ErrAlreadyAnswered {
+				t.Errorf("expected ErrAlreadyAnswered but got %v", err)
+			}
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			if !event.Answered() {
+				t.Error("expected automatic answer after deadline")
+			}
+		}
+
+		return nil
+	})
+
+	err := router.Handle(context.Background(), []vklongpoll.Update{
+		messageEventUpdate("snackbar"),
+		messageEventUpdate("silent"),
+		messageEventUpdate("slow"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 answers but got %+v", calls)
+	}
+
+	for i, eventID := range []string{"snackbar", "silent", "slow"} {
+		call := calls[i]
+		if call.Method != "messages.sendMessageEventAnswer" || call.Params.Get("event_id") != eventID ||
+			call.Params.Get("user_id") != "123" || call.Params.Get("peer_id") != "123" {
+			t.Errorf("unexpected answer for %s: %+v", eventID, call)
+		}
+	}
+
+	if eventData := calls[0].Params.Get("event_data"); eventData != `{"text":"liked like","type":"show_snackbar"}` {
+		t.Errorf("unexpected event data: %s", eventData)
+	}
+
+	if calls[1].Params.Has("event_data") || calls[2].Params.Has("event_data") {
+		t.Error("expected empty automatic answers")
+	}
+}
+
+func TestMessageEventAnswerInFlight(t *testing.T) {
+	api, server := startAPIServer(t)
+
+	answerStarted := make(chan struct{})
+	server.respond = func(call apiCall) string {
+		switch call.Params.Get("event_data") {
+		case `{"text":"fail","type":"show_snackbar"}`:
+			return `{"error": {"error_code": 10, "error_msg": "Internal server error"}}`
+		case `{"text":"wait","type":"show_snackbar"}`:
+			close(answerStarted)
+			time.Sleep(300 * time.Millisecond)
+		}
+		return `{"response": 1}`
+	}
+
+	router := bot.NewRouter()
+	router.API = api
+	router.MessageEventDeadline = 100 * time.Millisecond
+
+	router.OnMessageEvent(func(ctx *bot.Context, event *bot.MessageEvent) error {
+		switch event.EventID {
+		case "retry":
+			if err := event.ShowSnackbar(ctx, "fail"); err == nil {
+				t.Error("expected answer error")
+			}
+
+			if event.Answered() {
+				t.Error("failed answer marked as answered")
+			}
+
+			// Неудачный ответ можно повторить
+			if err := event.ShowSnackbar(ctx, "ok"); err != nil {
+				t.Error(err)
+			}
+		case "in flight":
+			answered := make(chan error)
+			go func() {
+				answered <- event.ShowSnackbar(ctx, "wait")
+			}()
+
+			<-answerStarted
+			start := time.Now()
+			if err := event.Answer(ctx); err != bot.ErrAlreadyAnswered {
+				t.Errorf("expected ErrAlreadyAnswered but got %v", err)
+			}
+
+			if time.Since(start) > 100*time.Millisecond {
+				t.Errorf("answer waited %s for the answer in flight", time.Since(start))
+			}
+
+			if err := <-answered; err != nil {
+				t.Error(err)
+			}
+		}
+
+		return nil
+	})
+
+	err := router.Handle(context.Background(), []vklongpoll.Update{
+		messageEventUpdate("retry"),
+		messageEventUpdate("in flight"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Автоматический ответ не отправляется, пока обработчик ждет свой ответ
+	if calls := server.Calls(); len(calls) != 3 {
+		t.Errorf("expected 3 answers but got %+v", calls)
+	}
+}
@@ -0,0 +1,123 @@
+// Пакет bot - обработка событий Bots Long Poll API: маршрутизация по типу события и командам
+//
+//	router := bot.NewRouter()
+//	router.Command("start", func(ctx *bot.Context) error {
+//		...
+//	}, "begin")
+//
+//	for {
+//		updates, err := lp.Recv(ctx)
+//		...
+//		router.Handle(ctx, updates)
+//	}
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+// Обработчик события
+type HandlerFunc func(ctx *Context) error
+
+// Маршрутизатор событий Bots Long Poll API
+// Если диалог находится в сценарии (см. State), событие получает обработчик состояния.
+// Иначе сообщения (message_new) проверяются на команды, а если команда не найдена,
+// передаются обработчикам типа события
+type Router struct {
+	Commands             CommandOptions                // Параметры распознавания команд
+	OnError              func(ctx *Context, err error) // Вызывается при ошибке обработчика (nil - ошибка возвращается из Handle)
+	API                  *API                          // Доступ к API для ответов обработчиков
+	MessageEventDeadline time.Duration                 // Время на ответ на нажатие callback-кнопки (0 - DefaultMessageEventDeadline)
+	States               StateStore                    // Хранилище состояний диалогов (nil - сценарии отключены)
+	StateTimeout         time.Duration                 // Сценарий сбрасывается, если диалог молчит дольше (0 - не сбрасывается)
+	OnStateTimeout       StateTimeoutHandler           // Вызывается при сбросе сценария по таймауту
+	CancelCommands       []string                      // Команды, сбрасывающие сценарий
+	OnCancel             HandlerFunc                   // Вызывается после отмены сценария командой
+	handlers             map[string][]HandlerFunc
+	commands             map[string]HandlerFunc
+	stateHandlers        map[stateHandlerKey]HandlerFunc
+	expireMx             sync.Mutex // Проверка и сброс истекших сценариев
+}
+
+// Создает маршрутизатор с параметрами команд по умолчанию и хранилищем состояний в памяти
+func NewRouter() *Router {
+	return &Router{
+		Commands:       DefaultCommandOptions(),
+		States:         NewMemoryStateStore(),
+		CancelCommands: DefaultCancelCommands,
+		handlers:       map[string][]HandlerFunc{},
+		commands:       map[string]HandlerFunc{},
+		stateHandlers:  map[stateHandlerKey]HandlerFunc{},
+	}
+}
+
+// Добавляет обработчик событий типа eventType (message_new, message_event и т.д.)
+func (r *Router) On(eventType string, handler HandlerFunc) {
+	r.handlers[eventType] = append(r.handlers[eventType], handler)
+}
+
+// Добавляет обработчик команды и ее псевдонимов (без учета регистра)
+func (r *Router) Command(name string, handler HandlerFunc, aliases ...string) {
+	for _, name := range append([]string{name}, aliases...) {
+		r.commands[strings.ToLower(name)] = handler
+	}
+}
+
+// Обрабатывает события по очереди
+// Ошибка обработчика не прерывает обработку остальных событий: если OnError не задан,
+// возвращается первая ошибка
+func (r *Router) Handle(ctx context.Context, updates []vklongpoll.Update) error {
+	var firstErr error
+
+	for _, u := range updates {
+		botCtx, err := newContext(ctx, u, r.API)
+		if err == nil {
+			err = r.dispatch(botCtx)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if r.OnError != nil {
+			r.OnError(botCtx, err)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Вызывает обработчики события
+func (r *Router) dispatch(ctx *Context) error {
+	if err := r.loadSession(ctx); err != nil {
+		return err
+	}
+
+	if handled, err := r.dispatchState(ctx); handled {
+		return err
+	}
+
+	if ctx.Message != nil {
+		if cmd, ok := ParseCommand(ctx.Message, r.Commands); ok {
+			if handler, ok := r.commands[cmd.Name]; ok {
+				ctx.Command = cmd
+				return handler(ctx)
+			}
+		}
+	}
+
+	for _, handler := range r.handlers[ctx.Type] {
+		if err := handler(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
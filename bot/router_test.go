@@ -0,0 +1,66 @@
+package bot_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+func TestRouter(t *testing.T) {
+	router := bot.NewRouter()
+
+	calls := []string{}
+	router.Command("start", func(ctx *bot.Context) error {
+		calls = append(calls, "start:"+ctx.Command.Arg(0))
+		return nil
+	}, "begin")
+
+	router.On("message_new", func(ctx *bot.Context) error {
+		calls = append(calls, "message:"+ctx.Message.Text)
+		return nil
+	})
+
+	handlerErr := errors.New("handler error")
+	router.On("message_typing_state", func(ctx *bot.Context) error {
+		return handlerErr
+	})
+
+	err := router.Handle(context.Background(), []vklongpoll.Update{
+		vklongpoll.Update(`{"type": "message_new", "group_id": 1, "event_id": "a", "object": {"message": {"id": 1, "peer_id": 123, "from_id": 123, "text": "/begin now"}}}`),
+		vklongpoll.Update(`{"type": "message_typing_state", "group_id": 1, "object": {"state": "typing"}}`),
+		vklongpoll.Update(`{"type": "message_new", "group_id": 1, "object": {"message": {"id": 2, "peer_id": 123, "from_id": 123, "text": "/unknown"}}}`),
+		vklongpoll.Update(`{"type": "message_new", "group_id": 1, "object": {"message": {"id": 3, "peer_id": 123, "from_id": 123, "text": "hi"}}}`),
+	})
+
+	if !errors.Is(err, handlerErr) {
+		t.Errorf("expected handler error but got %v", err)
+	}
+
+	want := []string{"start:now", "message:/unknown", "message:hi"}
+	if len(calls) != len(want) {
+		t.Fatalf("expected calls %q but got %q", want, calls)
+	}
+
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("expected calls %q but got %q", want, calls)
+			break
+		}
+	}
+
+	var handledErr error
+	router.OnError = func(ctx *bot.Context, err error) {
+		handledErr = err
+	}
+
+	if err := router.Handle(context.Background(), []vklongpoll.Update{vklongpoll.Update(`{"object": {}}`)}); err != nil {
+		t.Errorf("expected error to be passed to OnError but got %v", err)
+	}
+
+	if handledErr == nil {
+		t.Error("expected OnError to be called for update without type")
+	}
+}
@@ -0,0 +1,273 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buger/jsonparser"
+)
+
+// Ошибка Reply, Forward и Edit для события без сообщения
+var ErrNoMessage = errors.New("event has no message")
+
+// Параметры отправки сообщения
+type SendOptions struct {
+	Keyboard        string            // Клавиатура, JSON
+	Attachments     []string          // Вложения ("photo1_2", "doc1_2")
+	DisableMentions bool              // Не уведомлять упомянутых пользователей
+	DontParseLinks  bool              // Не создавать сниппеты ссылок
+	Params          map[string]string // Дополнительные параметры метода
+}
+
+// Переносит параметры в запрос
+func (o SendOptions) apply(params map[string]string) {
+	if o.Keyboard != "" {
+		params["keyboard"] = o.Keyboard
+	}
+
+	if len(o.Attachments) != 0 {
+		params["attachment"] = strings.Join(o.Attachments, ",")
+	}
+
+	if o.DisableMentions {
+		params["disable_mentions"] = "1"
+	}
+
+	if o.DontParseLinks {
+		params["dont_parse_links"] = "1"
+	}
+
+	for key, value := range o.Params {
+		params[key] = value
+	}
+}
+
+// Объединяет параметры отправки (для необязательного аргумента)
+func mergeSendOptions(opts []SendOptions) SendOptions {
+	merged := SendOptions{}
+	for _, opt := range opts {
+		if opt.Keyboard != "" {
+			merged.Keyboard = opt.Keyboard
+		}
+		merged.Attachments = append(merged.Attachments, opt.Attachments...)
+		merged.DisableMentions = merged.DisableMentions || opt.DisableMentions
+		merged.DontParseLinks = merged.DontParseLinks || opt.DontParseLinks
+		if len(opt.Params) != 0 && merged.Params == nil {
+			merged.Params = map[string]string{}
+		}
+		for key, value := range opt.Params {
+			merged.Params[key] = value
+		}
+	}
+	return merged
+}
+
+// Отвечает на сообщение в том же диалоге (с цитатой исходного сообщения)
+// Возвращает идентификатор отправленного сообщения
+func (c *Context) Reply(text string, opts ...SendOptions) (int64, error) {
+	if c.Message == nil {
+		return 0, ErrNoMessage
+	}
+
+	forward, err := c.forwardParam(true)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.send(c.Message.PeerID, text, forward, opts)
+}
+
+// Отправляет сообщение в диалог peerID
+func (c *Context) Send(peerID int64, text string, opts ...SendOptions) (int64, error) {
+	return c.send(peerID, text, "", opts)
+}
+
+// Пересылает входящее сообщение в диалог peerID с текстом text (может быть пустым)
+func (c *Context) Forward(peerID int64, text string, opts ...SendOptions) (int64, error) {
+	if c.Message == nil {
+		return 0, ErrNoMessage
+	}
+
+	forward, err := c.forwardParam(false)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.send(peerID, text, forward, opts)
+}
+
+// Редактирует сообщение бота в текущем диалоге
+func (c *Context) Edit(conversationMessageID int64, text string, opts ...SendOptions) error {
+	peerID, ok := c.PeerID()
+	if !ok {
+		return ErrNoMessage
+	}
+
+	params := map[string]string{
+		"peer_id":                 strconv.FormatInt(peerID, 10),
+		"conversation_message_id": strconv.FormatInt(conversationMessageID, 10),
+		"message":                 text,
+	}
+	mergeSendOptions(opts).apply(params)
+
+	_, err := c.api.Do(c, "messages.edit", params)
+	return err
+}
+
+// Возвращает диалог, из которого пришло событие (сообщение или нажатие кнопки)
+func (c *Context) PeerID() (int64, bool) {
+	switch {
+	case c.Message != nil:
+		return c.Message.PeerID, true
+	case c.MessageEvent != nil:
+		return c.MessageEvent.PeerID, true
+	case c.key != nil:
+		// Сброс сценария по таймауту (ExpireStates) приходит без события
+		return c.key.PeerID, true
+	}
+	return 0, false
+}
+
+// Возвращает ключ состояния диалога: диалог и автора события
+func (c *Context) sessionKey() (SessionKey, bool) {
+	switch {
+	case c.key != nil:
+		return *c.key, true
+	case c.Message != nil:
+		return SessionKey{PeerID: c.Message.PeerID, UserID: c.Message.FromID}, true
+	case c.MessageEvent != nil:
+		return SessionKey{PeerID: c.MessageEvent.PeerID, UserID: c.MessageEvent.UserID}, true
+	}
+	return SessionKey{}, false
+}
+
+// Вызывает messages.send
+func (c *Context) send(peerID int64, text, forward string, opts []SendOptions) (int64, error) {
+	params := map[string]string{
+		"peer_id":   strconv.FormatInt(peerID, 10),
+		"random_id": strconv.FormatInt(int64(c.nextRandomID()), 10),
+	}
+
+	if text != "" {
+		params["message"] = text
+	}
+
+	if forward != "" {
+		params["forward"] = forward
+	}
+
+	mergeSendOptions(opts).apply(params)
+
+	res, err := c.api.Do(c, "messages.send", params)
+	if err != nil {
+		return 0, err
+	}
+
+	return jsonparser.ParseInt(res)
+}
+
+// Возвращает параметр forward для входящего сообщения
+// В беседах сообщество не знает id сообщений, поэтому используется conversation_message_id
+func (c *Context) forwardParam(isReply bool) (string, error) {
+	forward := map[string]interface{}{
+		"peer_id":                  c.Message.PeerID,
+		"conversation_message_ids": []int64{c.Message.ConversationMessageID},
+	}
+
+	if isReply {
+		forward["is_reply"] = true
+	}
+
+	data, err := json.Marshal(forward)
+	return string(data), err
+}
+
+// Возвращает random_id для очередной отправки из обработчика
+// random_id зависит только от события и порядкового номера отправки, поэтому при повторной обработке
+// того же события (например, после перезапуска) ВКонтакте не отправит сообщения повторно.
+// Если random_id недавно получила другая отправка через тот же API, выбирается другой
+func (c *Context) nextRandomID() int32 {
+	c.sends++
+
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(c.GroupID, 10)))
+	h.Write([]byte{0})
+
+	if c.EventID != "" {
+		h.Write([]byte(c.EventID))
+	} else {
+		// Событие без event_id (например, пользовательский Long Poll) определяется содержимым
+		h.Write(c.Update)
+	}
+
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(c.sends)))
+
+	if c.api == nil {
+		return foldRandomID(h.Sum64())
+	}
+	return c.api.randomIDs.get(h.Sum64())
+}
+
+// Сколько последних random_id помнит API, чтобы не выдать один и тот же разным отправкам
+const randomIDHistory = 1 << 16
+
+// Выданные random_id
+// random_id - всего 31 бит, поэтому хеши разных отправок совпадают уже после десятков тысяч сообщений,
+// а ВКонтакте молча отбрасывает сообщение с повторным random_id
+type randomIDs struct {
+	keys  map[int32]uint64 // random_id -> хеш отправки
+	order []int32          // Кольцевой буфер выданных random_id для вытеснения старых
+	next  int
+	mx    sync.Mutex
+}
+
+// Возвращает random_id для хеша отправки: та же отправка всегда получает тот же random_id,
+// а если свернутый хеш уже выдан другой отправке, берется следующий кандидат
+func (r *randomIDs) get(key uint64) int32 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.keys == nil {
+		r.keys = map[int32]uint64{}
+	}
+
+	for attempt := uint64(0); ; attempt++ {
+		randomID := foldRandomID(key + attempt*0x9e3779b97f4a7c15)
+
+		owner, ok := r.keys[randomID]
+		if ok && owner == key {
+			return randomID
+		}
+
+		if !ok {
+			r.remember(randomID, key)
+			return randomID
+		}
+	}
+}
+
+// Запоминает выданный random_id, вытесняя самый старый, вызывается под блокировкой
+func (r *randomIDs) remember(randomID int32, key uint64) {
+	if len(r.order) < randomIDHistory {
+		r.order = append(r.order, randomID)
+	} else {
+		delete(r.keys, r.order[r.next])
+		r.order[r.next] = randomID
+		r.next = (r.next + 1) % randomIDHistory
+	}
+	r.keys[randomID] = key
+}
+
+// Сворачивает 64-битный хеш в random_id - положительное 32-битное число, 0 отключает защиту от повторов
+func foldRandomID(sum uint64) int32 {
+	randomID := int32((sum ^ sum>>32) & 0x7fffffff)
+	if randomID == 0 {
+		randomID = 1
+	}
+	return randomID
+}
@@ -0,0 +1,91 @@
+package bot_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+func TestContextSend(t *testing.T) {
+	api, server := startAPIServer(t)
+	server.respond = func(call apiCall) string {
+		return `{"response": 42}`
+	}
+
+	router := bot.NewRouter()
+	router.API = api
+
+	router.On("message_new", func(ctx *bot.Context) error {
+		if id, err := ctx.Reply("pong", bot.SendOptions{Keyboard: `{"buttons": []}`}); err != nil || id != 42 {
+			t.Errorf("unexpected reply result: %d, %v", id, err)
+		}
+
+		if _, err := ctx.Send(456, "hello", bot.SendOptions{Attachments: []string{"photo1_2", "doc1_2"}, DisableMentions: true}); err != nil {
+			return err
+		}
+
+		if _, err := ctx.Forward(789, ""); err != nil {
+			return err
+		}
+
+		return ctx.Edit(7, "edited", bot.SendOptions{Params: map[string]string{"keep_forward_messages": "1"}})
+	})
+
+	router.OnMessageEvent(func(ctx *bot.Context, event *bot.MessageEvent) error {
+		if _, err := ctx.Reply("no message"); err != bot.ErrNoMessage {
+			t.Errorf("expected ErrNoMessage but got %v", err)
+		}
+		return nil
+	})
+
+	update := vklongpoll.Update(`{"type": "message_new", "group_id": 1, "event_id": "abc", "object": {"message": {"id": 0, "conversation_message_id": 5, "peer_id": 2000000001, "from_id": 123, "text": "ping"}}}`)
+	for i := 0; i < 2; i++ {
+		if err := router.Handle(context.Background(), []vklongpoll.Update{update}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := router.Handle(context.Background(), []vklongpoll.Update{messageEventUpdate("event")}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 9 {
+		t.Fatalf("expected 9 calls but got %d: %+v", len(calls), calls)
+	}
+
+	reply, send, forward, edit := calls[0].Params, calls[1].Params, calls[2].Params, calls[3].Params
+
+	if calls[0].Method != "messages.send" || reply.Get("peer_id") != "2000000001" || reply.Get("message") != "pong" ||
+		reply.Get("forward") != `{"conversation_message_ids":[5],"is_reply":true,"peer_id":2000000001}` || reply.Get("keyboard") != `{"buttons": []}` {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+
+	if send.Get("peer_id") != "456" || send.Get("attachment") != "photo1_2,doc1_2" || send.Get("disable_mentions") != "1" || send.Has("forward") {
+		t.Errorf("unexpected send: %+v", send)
+	}
+
+	if forward.Get("peer_id") != "789" || forward.Has("message") || forward.Get("forward") != `{"conversation_message_ids":[5],"peer_id":2000000001}` {
+		t.Errorf("unexpected forward: %+v", forward)
+	}
+
+	if calls[3].Method != "messages.edit" || edit.Get("peer_id") != "2000000001" || edit.Get("conversation_message_id") != "7" ||
+		edit.Get("message") != "edited" || edit.Get("keep_forward_messages") != "1" {
+		t.Errorf("unexpected edit: %+v", edit)
+	}
+
+	randomIDs := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		first, second := calls[i].Params.Get("random_id"), calls[4+i].Params.Get("random_id")
+		if first == "" || first == "0" || first != second {
+			t.Errorf("expected same random_id for repeated event but got %q and %q", first, second)
+		}
+		randomIDs[first] = true
+	}
+
+	if len(randomIDs) != 3 {
+		t.Errorf("expected different random_id for each send but got %v", randomIDs)
+	}
+}
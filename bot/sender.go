@@ -0,0 +1,309 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vkapiexecutor/response"
+)
+
+// Количество запросов в секунду на токен по умолчанию (лимит VK API для сообществ)
+var DefaultSenderRateLimit = 20
+
+// Время ожидания других вызовов перед отправкой пачки по умолчанию
+var DefaultSenderBatchDelay = 20 * time.Millisecond
+
+// Методы, которые по умолчанию отправляются без ожидания BatchDelay: у ответа на callback-кнопку мало времени
+var DefaultSenderUrgentMethods = []string{"messages.sendMessageEventAnswer"}
+
+// Максимальное количество вызовов в одном execute
+const MaxExecuteCalls = 25
+
+// Параметры Sender
+type SenderOptions struct {
+	RateLimit  int           // Запросов в секунду на токен (0 - DefaultSenderRateLimit)
+	BatchSize  int           // Вызовов в одном execute (0 или больше MaxExecuteCalls - MaxExecuteCalls, 1 - без execute)
+	BatchDelay time.Duration // Время ожидания других вызовов перед отправкой (0 - DefaultSenderBatchDelay)
+	Urgent     []string      // Методы, которые отправляются без ожидания BatchDelay, но с учетом RateLimit (nil - DefaultSenderUrgentMethods)
+}
+
+// Очередь исходящих вызовов VK API
+// Соблюдает лимит запросов на каждый токен и объединяет до 25 вызовов одного токена в один запрос execute.
+// Безопасно использовать из нескольких горутин
+type Sender struct {
+	exec   *executor.Executor
+	opts   SenderOptions
+	urgent map[string]bool
+	queues map[string]*senderQueue // Очереди токенов, у которых есть неотправленные вызовы
+	mx     sync.Mutex
+}
+
+// Очередь вызовов одного токена, пока она есть, ее отправляет горутина
+type senderQueue struct {
+	calls []*Future
+	wake  chan struct{} // Прерывает ожидание BatchDelay для срочного вызова
+	next  time.Time     // Время, раньше которого нельзя отправить следующий запрос
+}
+
+// Результат вызова, поставленного в очередь Sender
+type Future struct {
+	Method string
+	Params map[string]string
+	ctx    context.Context
+	done   chan struct{}
+	result []byte
+	err    error
+}
+
+// Имя метода API, допустимое в коде execute
+var methodNameRegexp = regexp.MustCompile(`^[a-zA-Z]+\.[a-zA-Z]+$`)
+
+// Создает очередь вызовов, запросы выполняются через exec
+func NewSender(exec *executor.Executor, opts SenderOptions) *Sender {
+	if opts.RateLimit <= 0 {
+		opts.RateLimit = DefaultSenderRateLimit
+	}
+
+	if opts.BatchSize <= 0 || opts.BatchSize > MaxExecuteCalls {
+		opts.BatchSize = MaxExecuteCalls
+	}
+
+	if opts.BatchDelay <= 0 {
+		opts.BatchDelay = DefaultSenderBatchDelay
+	}
+
+	if opts.Urgent == nil {
+		opts.Urgent = DefaultSenderUrgentMethods
+	}
+
+	urgent := make(map[string]bool, len(opts.Urgent))
+	for _, method := range opts.Urgent {
+		urgent[method] = true
+	}
+
+	return &Sender{
+		exec:   exec,
+		opts:   opts,
+		urgent: urgent,
+		queues: map[string]*senderQueue{},
+	}
+}
+
+// Ставит вызов метода в очередь токена token
+// Если ctx отменен до отправки, вызов не выполняется и завершается с ошибкой ctx
+func (s *Sender) Enqueue(ctx context.Context, token, method string, params map[string]string) *Future {
+	future := &Future{Method: method, Params: params, ctx: ctx, done: make(chan struct{})}
+
+	if !methodNameRegexp.MatchString(method) {
+		future.complete(nil, fmt.Errorf("invalid method name %q", method))
+		return future
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	queue, ok := s.queues[token]
+	if !ok {
+		queue = &senderQueue{wake: make(chan struct{}, 1)}
+		s.queues[token] = queue
+		go s.run(token, queue)
+	}
+
+	if !s.urgent[method] {
+		queue.calls = append(queue.calls, future)
+		return future
+	}
+
+	// Срочный вызов попадает в ближайшую пачку
+	queue.calls = append([]*Future{future}, queue.calls...)
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+
+	return future
+}
+
+// Ставит вызов в очередь и ждет результат
+// Отмена ctx прекращает ожидание, а если вызов еще не отправлен - отменяет его
+func (s *Sender) Call(ctx context.Context, token, method string, params map[string]string) ([]byte, error) {
+	return s.Enqueue(ctx, token, method, params).Wait(ctx)
+}
+
+// Отправляет вызовы очереди, пока она не опустеет
+func (s *Sender) run(token string, queue *senderQueue) {
+	select {
+	case <-time.After(s.opts.BatchDelay):
+	case <-queue.wake:
+	}
+
+	for {
+		s.mx.Lock()
+		if wait := time.Until(queue.next); wait > 0 {
+			s.mx.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+
+		batch := queue.take(s.opts.BatchSize)
+		if len(batch) == 0 {
+			// Лимит уже не действует, поэтому очередь можно удалить: следующий вызов создаст новую
+			delete(s.queues, token)
+			s.mx.Unlock()
+			return
+		}
+
+		queue.next = time.Now().Add(time.Second / time.Duration(s.opts.RateLimit))
+		s.mx.Unlock()
+
+		s.send(token, batch)
+	}
+}
+
+// Забирает из очереди до n вызовов, вызовы с отмененным ctx завершаются без отправки
+// Вызывается под блокировкой
+func (q *senderQueue) take(n int) []*Future {
+	batch := []*Future{}
+	for len(q.calls) != 0 && len(batch) < n {
+		call := q.calls[0]
+		q.calls = q.calls[1:]
+
+		if err := call.ctx.Err(); err != nil {
+			call.complete(nil, err)
+			continue
+		}
+
+		batch = append(batch, call)
+	}
+	return batch
+}
+
+// Выполняет пачку вызовов: один вызов - напрямую, несколько - через execute
+// В execute вызовы разных контекстов, поэтому он выполняется без отмены
+func (s *Sender) send(token string, batch []*Future) {
+	ctx := context.Background()
+
+	if len(batch) == 1 {
+		call := batch[0]
+		res, err := s.do(call.ctx, token, call.Method, call.Params)
+		if err != nil {
+			call.complete(nil, err)
+			return
+		}
+
+		value, _, _, err := jsonparser.Get(res.Body(), "response")
+		call.complete(value, err)
+		return
+	}
+
+	code, err := executeCode(batch)
+	if err != nil {
+		for _, call := range batch {
+			call.complete(nil, err)
+		}
+		return
+	}
+
+	res, err := s.do(ctx, token, "execute", map[string]string{"code": code})
+	if err != nil {
+		for _, call := range batch {
+			call.complete(nil, err)
+		}
+		return
+	}
+
+	completeExecute(res.Body(), batch)
+}
+
+// Выполняет метод API
+func (s *Sender) do(ctx context.Context, token, method string, params map[string]string) (response.Response, error) {
+	req := request.New()
+	req.Method(method)
+	req.GetParams().AccessToken(token)
+	for key, value := range params {
+		req.GetParams().Set(key, value)
+	}
+
+	return s.exec.DoRequestCtx(ctx, req)
+}
+
+// Возвращает код execute, который вызывает методы пачки и возвращает массив результатов
+func executeCode(batch []*Future) (string, error) {
+	calls := make([]string, len(batch))
+	for i, call := range batch {
+		params, err := json.Marshal(call.Params)
+		if err != nil {
+			return "", err
+		}
+		calls[i] = "API." + call.Method + "(" + string(params) + ")"
+	}
+
+	return "return [" + strings.Join(calls, ",") + "];", nil
+}
+
+// Раздает результаты execute вызовам
+// Неудачный вызов возвращает false, а его ошибка - очередной элемент execute_errors
+func completeExecute(body []byte, batch []*Future) {
+	executeErrors := []error{}
+	jsonparser.ArrayEach(body, func(value []byte, _ jsonparser.ValueType, _ int, _ error) {
+		code, _ := jsonparser.GetInt(value, "error_code")
+		message, _ := jsonparser.GetString(value, "error_msg")
+		executeErrors = append(executeErrors, response.NewError(message, int(code)))
+	}, "execute_errors")
+
+	i := 0
+	jsonparser.ArrayEach(body, func(value []byte, dataType jsonparser.ValueType, _ int, _ error) {
+		if i >= len(batch) {
+			return
+		}
+
+		call := batch[i]
+		i++
+
+		if dataType == jsonparser.Boolean && string(value) == "false" && len(executeErrors) != 0 {
+			call.complete(nil, executeErrors[0])
+			executeErrors = executeErrors[1:]
+			return
+		}
+
+		if dataType == jsonparser.String {
+			// ArrayEach возвращает строку без кавычек
+			value = []byte(`"` + string(value) + `"`)
+		}
+
+		call.complete(append([]byte(nil), value...), nil)
+	}, "response")
+
+	for _, call := range batch[i:] {
+		call.complete(nil, fmt.Errorf("execute returned no result for %s", call.Method))
+	}
+}
+
+// Завершает вызов
+func (f *Future) complete(result []byte, err error) {
+	f.result, f.err = result, err
+	close(f.done)
+}
+
+// Возвращает канал, который закрывается после выполнения вызова
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Ждет выполнения вызова и возвращает поле response его ответа
+func (f *Future) Wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
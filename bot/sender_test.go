@@ -0,0 +1,205 @@
+package bot_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/response"
+	"github.com/ciricc/vklongpoll/bot"
+)
+
+// Эмулирует execute: каждый вызов возвращает "ok", вызов с message "fail" - false и ошибку в execute_errors
+func respondExecute(call apiCall) string {
+	if call.Method != "execute" {
+		return `{"response": 1}`
+	}
+
+	code := strings.TrimSuffix(strings.TrimPrefix(call.Params.Get("code"), "return ["), "];")
+	calls := strings.Split(code, "),API.")
+
+	results := make([]string, len(calls))
+	executeErrors := []string{}
+	for i, apiCall := range calls {
+		if strings.Contains(apiCall, `"message":"fail"`) {
+			results[i] = "false"
+			executeErrors = append(executeErrors, `{"method": "messages.send", "error_code": 7, "error_msg": "Permission to perform this action is denied"}`)
+			continue
+		}
+		results[i] = `"ok"`
+	}
+
+	return `{"response": [` + strings.Join(results, ",") + `], "execute_errors": [` + strings.Join(executeErrors, ",") + `]}`
+}
+
+func TestSenderBatching(t *testing.T) {
+	_, server := startAPIServer(t)
+	server.respond = respondExecute
+
+	sender := bot.NewSender(executor.New(), bot.SenderOptions{BatchDelay: 50 * time.Millisecond})
+
+	futures := make([]*bot.Future, 30)
+	wg := sync.WaitGroup{}
+	for i := range futures {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			message := "hello"
+			if i == 3 {
+				message = "fail"
+			}
+			futures[i] = sender.Enqueue(context.Background(), "token", "messages.send", map[string]string{"peer_id": "1", "message": message})
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, future := range futures {
+		res, err := future.Wait(context.Background())
+		if err != nil {
+			failed++
+			if apiErr, ok := err.(*response.Error); !ok || apiErr.IntCode() != 7 {
+				t.Errorf("unexpected call error: %v", err)
+			}
+			continue
+		}
+
+		if string(res) != `"ok"` {
+			t.Errorf("unexpected call result: %s", res)
+		}
+	}
+
+	if failed != 1 {
+		t.Errorf("expected 1 failed call but got %d", failed)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 execute requests but got %d", len(calls))
+	}
+
+	sizes := []int{}
+	for _, call := range calls {
+		if call.Method != "execute" || call.Params.Get("access_token") != "token" {
+			t.Errorf("unexpected request: %+v", call)
+		}
+		sizes = append(sizes, strings.Count(call.Params.Get("code"), "API."))
+	}
+
+	if sizes[0] != bot.MaxExecuteCalls || sizes[1] != 5 {
+		t.Errorf("unexpected batch sizes: %v", sizes)
+	}
+}
+
+func TestSenderRateLimit(t *testing.T) {
+	_, server := startAPIServer(t)
+
+	sender := bot.NewSender(executor.New(), bot.SenderOptions{RateLimit: 10, BatchSize: 1, BatchDelay: time.Millisecond})
+
+	start := time.Now()
+	futures := []*bot.Future{}
+	for i := 0; i < 4; i++ {
+		futures = append(futures, sender.Enqueue(context.Background(), "token", "messages.send", nil))
+	}
+
+	// Другой токен ограничивается отдельно
+	other, err := sender.Call(context.Background(), "other_token", "messages.send", nil)
+	if err != nil || string(other) != "1" {
+		t.Errorf("unexpected other token result: %s, %v", other, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("other token waited for the first one: %s", elapsed)
+	}
+
+	for _, future := range futures {
+		if _, err := future.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 4 запроса с лимитом 10 в секунду: между первым и последним не меньше 300ms
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("rate limit is not applied: %s", elapsed)
+	}
+
+	calls := server.Calls()
+	if len(calls) != 5 {
+		t.Fatalf("expected 5 requests but got %d", len(calls))
+	}
+
+	for _, call := range calls {
+		if call.Method != "messages.send" {
+			t.Errorf("single call should not use execute: %+v", call)
+		}
+	}
+}
+
+func TestSenderErrors(t *testing.T) {
+	_, server := startAPIServer(t)
+	server.respond = func(call apiCall) string {
+		return `{"error": {"error_code": 6, "error_msg": "Too many requests per second"}}`
+	}
+
+	sender := bot.NewSender(executor.New(), bot.SenderOptions{BatchDelay: time.Millisecond})
+
+	if _, err := sender.Call(context.Background(), "token", "messages.send", nil); err == nil {
+		t.Error("expected api error")
+	}
+
+	if _, err := sender.Call(context.Background(), "token", `execute"); API.users.get(`, nil); err == nil {
+		t.Error("expected invalid method name error")
+	}
+
+	if len(server.Calls()) != 1 {
+		t.Errorf("invalid method should not be sent: %+v", server.Calls())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sender.Call(ctx, "token", "messages.send", nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestSenderContext(t *testing.T) {
+	_, server := startAPIServer(t)
+	server.respond = respondExecute
+
+	sender := bot.NewSender(executor.New(), bot.SenderOptions{BatchDelay: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := sender.Enqueue(ctx, "token", "messages.send", map[string]string{"message": "cancelled"})
+	queued := sender.Enqueue(context.Background(), "token", "messages.send", map[string]string{"message": "queued"})
+	cancel()
+
+	// Срочный метод не ждет BatchDelay
+	start := time.Now()
+	if _, err := sender.Call(context.Background(), "token", "messages.sendMessageEventAnswer", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("urgent call waited for batch delay: %s", elapsed)
+	}
+
+	if _, err := cancelled.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("expected cancelled call to fail with context.Canceled but got %v", err)
+	}
+
+	if _, err := queued.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Отмененный вызов не отправляется
+	for _, call := range server.Calls() {
+		if strings.Contains(call.Params.Get("code"), "cancelled") || call.Params.Get("message") == "cancelled" {
+			t.Errorf("cancelled call was sent: %+v", call)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package vklongpoll
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Ошибка, возвращаемая Recv после вызова Close
+var ErrClosed = errors.New("long poll closed")
+
+// Закрывает соединение: отменяет текущий запрос, после чего Recv возвращает ErrClosed
+// Приостановленное соединение (Pause) возобновляется.
+// Затем ждет завершения текущего Recv и обработчиков, запущенных через Go, но не дольше, чем позволяет ctx,
+// и закрывает ресурсы, добавленные через AddCloser (например, Spool и Archive сохраняют данные на диск).
+// Ресурсы закрываются, даже если ctx истек: возвращается ошибка ctx
+func (v *VkLongPoll) Close(ctx context.Context) error {
+	v.closeMx.Lock()
+	v.closed = true
+	if v.cancelPoll != nil {
+		v.cancelPoll()
+	}
+	closers := v.closers
+	v.closers = nil
+	v.closeMx.Unlock()
+
+	// Будим Recv, ожидающие Resume, чтобы они вернули ErrClosed
+	v.Resume()
+
+	drained := make(chan struct{})
+	go func() {
+		v.mx.Lock()
+		v.mx.Unlock()
+		v.handlers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		v.releaseBuffer()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// Закрываем в обратном порядке, как defer
+	for i := len(closers) - 1; i >= 0; i-- {
+		if closeErr := closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Запускает обработчик событий в горутине, Close ждет его завершения
+// Возвращает ErrClosed, если соединение уже закрыто
+func (v *VkLongPoll) Go(handler func()) error {
+	v.closeMx.Lock()
+	defer v.closeMx.Unlock()
+
+	if v.closed {
+		return ErrClosed
+	}
+
+	v.handlers.Add(1)
+	go func() {
+		defer v.handlers.Done()
+		handler()
+	}()
+
+	return nil
+}
+
+// Добавляет ресурс (журнал, архив, хранилище состояния), который Close закроет
+// после завершения обработчиков. Если соединение уже закрыто, ресурс закрывается сразу
+func (v *VkLongPoll) AddCloser(closer io.Closer) error {
+	v.closeMx.Lock()
+	if !v.closed {
+		v.closers = append(v.closers, closer)
+		v.closeMx.Unlock()
+		return nil
+	}
+	v.closeMx.Unlock()
+
+	return closer.Close()
+}
+
+// Проверяет, закрыто ли соединение
+func (v *VkLongPoll) isClosed() bool {
+	v.closeMx.Lock()
+	defer v.closeMx.Unlock()
+
+	return v.closed
+}
+
+// Регистрирует начало запроса, возвращает контекст, который отменится при Close
+func (v *VkLongPoll) beginPoll(ctx context.Context) (context.Context, error) {
+	v.closeMx.Lock()
+	defer v.closeMx.Unlock()
+
+	if v.closed {
+		return nil, ErrClosed
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancelPoll = cancel
+
+	return ctx, nil
+}
+
+// Регистрирует окончание запроса
+func (v *VkLongPoll) endPoll() {
+	v.closeMx.Lock()
+	defer v.closeMx.Unlock()
+
+	if v.cancelPoll != nil {
+		v.cancelPoll()
+		v.cancelPoll = nil
+	}
+}
@@ -0,0 +1,179 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestClose(t *testing.T) {
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+
+	defer longPollServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := json.Marshal(getServerResponse(longPollServer.URL))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(res)
+	}))
+
+	defer apiServer.Close()
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	serverUpdater := vklongpoll.WithServerUpdater(vklongpoll.UniversalServerUpdater(request.New(), executor.New()))
+
+	lp := vklongpoll.New()
+	recvErr := make(chan error)
+	go func() {
+		_, err := lp.Recv(context.Background(), serverUpdater)
+		recvErr <- err
+	}()
+
+	// Ждем, пока запрос дойдет до Long Poll сервера
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := lp.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-recvErr; !errors.Is(err, vklongpoll.ErrClosed) {
+		t.Errorf("expected in-flight Recv to return ErrClosed but got %v", err)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("close took %s, in-flight request was not cancelled", time.Since(start))
+	}
+
+	if _, err := lp.Recv(context.Background(), serverUpdater); !errors.Is(err, vklongpoll.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close but got %v", err)
+	}
+}
+
+// Ресурс, который запоминает, что его закрыли
+type testCloser struct {
+	closed chan struct{}
+}
+
+func (c *testCloser) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestCloseDrain(t *testing.T) {
+	t.Run("waits for handlers and closes resources", func(t *testing.T) {
+		lp := vklongpoll.New()
+		closer := &testCloser{closed: make(chan struct{})}
+		lp.AddCloser(closer)
+
+		handled := make(chan struct{})
+		err := lp.Go(func() {
+			time.Sleep(50 * time.Millisecond)
+			select {
+			case <-closer.closed:
+				t.Error("resource closed before handler returned")
+			default:
+			}
+			close(handled)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lp.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-handled:
+		default:
+			t.Error("close returned before handler")
+		}
+
+		select {
+		case <-closer.closed:
+		default:
+			t.Error("resource was not closed")
+		}
+
+		if err := lp.Go(func() {}); !errors.Is(err, vklongpoll.ErrClosed) {
+			t.Errorf("expected ErrClosed from Go after Close but got %v", err)
+		}
+
+		late := &testCloser{closed: make(chan struct{})}
+		lp.AddCloser(late)
+		select {
+		case <-late.closed:
+		default:
+			t.Error("resource added after Close was not closed")
+		}
+	})
+
+	t.Run("closes resources after deadline", func(t *testing.T) {
+		lp := vklongpoll.New()
+		closer := &testCloser{closed: make(chan struct{})}
+		lp.AddCloser(closer)
+
+		release := make(chan struct{})
+		defer close(release)
+		lp.Go(func() {
+			<-release
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if err := lp.Close(ctx); err != context.DeadlineExceeded {
+			t.Errorf("expected deadline exceeded but got %v", err)
+		}
+
+		select {
+		case <-closer.closed:
+		default:
+			t.Error("resource was not closed")
+		}
+	})
+
+	t.Run("closes spool", func(t *testing.T) {
+		serverUpdater, closeServers := startLongPollServers(t, longPollBatch(t, 1))
+		defer closeServers()
+
+		spool, err := vklongpoll.OpenSpool(filepath.Join(t.TempDir(), "spool.jsonl"), vklongpoll.SpoolOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lp := vklongpoll.New()
+		batch, err := spool.Recv(context.Background(), lp, serverUpdater)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lp.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := spool.Done(batch.ID); err != vklongpoll.ErrSpoolClosed {
+			t.Errorf("expected spool to be closed but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,266 @@
+package vklongpoll
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Настройки соединения в формате JSON
+// Функции и наблюдатели (ServerUpdater, ParamsMerger, Observer) в конфигурацию не входят
+type optionsConfig struct {
+	Wait             *configDuration     `json:"wait,omitempty"`
+	Version          *int                `json:"version,omitempty"`
+	Mode             *Mode               `json:"mode,omitempty"`
+	UpdatesJsonPath  []string            `json:"updates_json_path,omitempty"`
+	Params           map[string][]string `json:"params,omitempty"`
+	MaxResponseBytes *int64              `json:"max_response_bytes,omitempty"`
+	ZeroCopy         *bool               `json:"zero_copy,omitempty"`
+	NegotiateVersion *bool               `json:"negotiate_version,omitempty"`
+	Filter           *Filter             `json:"filter,omitempty"`
+}
+
+// Длительность в JSON: строка в формате time.ParseDuration ("90s") или число секунд
+type configDuration time.Duration
+
+// Разбирает длительность
+func (d *configDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var seconds int64
+		if err := json.Unmarshal(b, &seconds); err != nil {
+			return errors.New("duration must be a string like \"90s\" or a number of seconds")
+		}
+		*d = configDuration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+
+	wait, err := parseWait(s)
+	if err != nil {
+		return err
+	}
+
+	*d = configDuration(wait)
+	return nil
+}
+
+// Записывает длительность строкой
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Разбирает длительность из строки ("90s" или "90")
+func parseWait(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Проверяет корректность настроек
+func (v *VkLongPollOptions) Validate() error {
+	if v.Wait < 0 {
+		return fmt.Errorf("wait must not be negative: %s", v.Wait)
+	}
+
+	if v.Version < 0 {
+		return fmt.Errorf("version must not be negative: %d", v.Version)
+	}
+
+	if err := v.Mode.Validate(); err != nil {
+		return err
+	}
+
+	if len(v.UpdatesJsonPath) == 0 {
+		return errors.New("updates json path is empty")
+	}
+
+	if v.MaxResponseBytes < 0 {
+		return fmt.Errorf("max response bytes must not be negative: %d", v.MaxResponseBytes)
+	}
+
+	return nil
+}
+
+// Создает настройки из JSON документа
+// Незаданные поля получают значения по умолчанию, неизвестные поля считаются ошибкой:
+//
+//	{"wait": "25s", "version": 3, "mode": "attachments|pts", "params": {"foo": ["bar"]}}
+func OptionsFromJSON(data []byte) (*VkLongPollOptions, error) {
+	config := optionsConfig{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("decode options error: %w", err)
+	}
+
+	// После объекта допускаются только пробелы
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, errors.New("decode options error: unexpected data after options object")
+	}
+
+	opt := NewOptions()
+	config.apply(opt)
+
+	if err := opt.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	return opt, nil
+}
+
+// Имена переменных окружения (без префикса) для OptionsFromEnv
+var envOptions = map[string]func(opt *VkLongPollOptions, value string) error{
+	"WAIT": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.Wait, err = parseWait(value)
+		return err
+	},
+	"VERSION": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.Version, err = strconv.Atoi(value)
+		return err
+	},
+	"MODE": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.Mode, err = ParseMode(value)
+		return err
+	},
+	"UPDATES_JSON_PATH": func(opt *VkLongPollOptions, value string) error {
+		opt.UpdatesJsonPath = strings.Split(value, ".")
+		return nil
+	},
+	"PARAMS": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.Params, err = url.ParseQuery(value)
+		return err
+	},
+	"MAX_RESPONSE_BYTES": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.MaxResponseBytes, err = strconv.ParseInt(value, 10, 64)
+		return err
+	},
+	"ZERO_COPY": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.ZeroCopy, err = strconv.ParseBool(value)
+		return err
+	},
+	"NEGOTIATE_VERSION": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.NegotiateVersion, err = strconv.ParseBool(value)
+		return err
+	},
+	"FILTER": func(opt *VkLongPollOptions, value string) (err error) {
+		opt.Filter, err = CompileFilter(value)
+		return err
+	},
+}
+
+// Создает настройки из переменных окружения с префиксом prefix:
+//
+//	VKLP_WAIT=25s
+//	VKLP_VERSION=3
+//	VKLP_MODE=attachments,pts
+//	VKLP_UPDATES_JSON_PATH=updates
+//	VKLP_PARAMS=foo=bar&baz=1
+//	VKLP_MAX_RESPONSE_BYTES=1048576
+//	VKLP_ZERO_COPY=true
+//	VKLP_NEGOTIATE_VERSION=true
+//	VKLP_FILTER=code == 4 && !flags.outbox
+//
+// Переменная с префиксом и неизвестным именем считается ошибкой (скорее всего, это опечатка)
+func OptionsFromEnv(prefix string) (*VkLongPollOptions, error) {
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+	opt := NewOptions()
+
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		setOption, ok := envOptions[strings.TrimPrefix(name, prefix)]
+		if !ok {
+			return nil, fmt.Errorf("unknown option variable %s, supported: %s", name, strings.Join(envOptionNames(prefix), ", "))
+		}
+
+		if err := setOption(opt, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if err := opt.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	return opt, nil
+}
+
+// Возвращает настройки в формате OptionsFromJSON, например, для вывода в лог при запуске
+func DumpOptions(opt *VkLongPollOptions) ([]byte, error) {
+	wait := configDuration(opt.Wait)
+	config := optionsConfig{
+		Wait:             &wait,
+		Version:          &opt.Version,
+		Mode:             &opt.Mode,
+		UpdatesJsonPath:  opt.UpdatesJsonPath,
+		Params:           opt.Params,
+		MaxResponseBytes: &opt.MaxResponseBytes,
+		ZeroCopy:         &opt.ZeroCopy,
+		NegotiateVersion: &opt.NegotiateVersion,
+		Filter:           opt.Filter,
+	}
+
+	return json.Marshal(config)
+}
+
+// Переносит заданные в конфигурации поля в настройки
+func (c *optionsConfig) apply(opt *VkLongPollOptions) {
+	if c.Wait != nil {
+		opt.Wait = time.Duration(*c.Wait)
+	}
+
+	if c.Version != nil {
+		opt.Version = *c.Version
+	}
+
+	if c.Mode != nil {
+		opt.Mode = *c.Mode
+	}
+
+	if c.UpdatesJsonPath != nil {
+		opt.UpdatesJsonPath = c.UpdatesJsonPath
+	}
+
+	if c.Params != nil {
+		opt.Params = c.Params
+	}
+
+	if c.MaxResponseBytes != nil {
+		opt.MaxResponseBytes = *c.MaxResponseBytes
+	}
+
+	if c.ZeroCopy != nil {
+		opt.ZeroCopy = *c.ZeroCopy
+	}
+
+	if c.NegotiateVersion != nil {
+		opt.NegotiateVersion = *c.NegotiateVersion
+	}
+
+	if c.Filter != nil {
+		opt.Filter = c.Filter
+	}
+}
+
+// Возвращает список поддерживаемых переменных окружения с префиксом
+func envOptionNames(prefix string) []string {
+	names := make([]string, 0, len(envOptions))
+	for name := range envOptions {
+		names = append(names, prefix+name)
+	}
+	sort.Strings(names)
+	return names
+}
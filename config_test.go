@@ -0,0 +1,141 @@
+package vklongpoll_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestOptionsFromJSON(t *testing.T) {
+	t.Run("all fields", func(t *testing.T) {
+		opt, err := vklongpoll.OptionsFromJSON([]byte(`{
+			"wait": "25s",
+			"version": 10,
+			"mode": "attachments|pts",
+			"updates_json_path": ["response", "updates"],
+			"params": {"foo": ["bar"]},
+			"max_response_bytes": 1024,
+			"zero_copy": true,
+			"negotiate_version": true
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedOpt := vklongpoll.NewOptions()
+		expectedOpt.Wait = 25 * time.Second
+		expectedOpt.Version = 10
+		expectedOpt.Mode = vklongpoll.Attachments | vklongpoll.ReturnPts
+		expectedOpt.UpdatesJsonPath = []string{"response", "updates"}
+		expectedOpt.Params = url.Values{"foo": {"bar"}}
+		expectedOpt.MaxResponseBytes = 1024
+		expectedOpt.ZeroCopy = true
+		expectedOpt.NegotiateVersion = true
+
+		if !reflect.DeepEqual(opt, expectedOpt) {
+			t.Errorf("expected options %v but got %v", expectedOpt, opt)
+		}
+	})
+
+	t.Run("defaults and wait in seconds", func(t *testing.T) {
+		opt, err := vklongpoll.OptionsFromJSON([]byte(`{"wait": 25}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opt.Wait != 25*time.Second || opt.Version != vklongpoll.DefaultVersion {
+			t.Errorf("unexpected options: %v", opt)
+		}
+	})
+
+	t.Run("strict validation", func(t *testing.T) {
+		cases := []string{
+			`{"wiat": "25s"}`,
+			`{"wait": "forever"}`,
+			`{"mode": "typing"}`,
+			`{"version": -1}`,
+			`{"updates_json_path": []}`,
+			`{"wait": "25s"} junk`,
+			`{"wait": "25s"}{"version": 2}`,
+		}
+
+		for _, c := range cases {
+			if _, err := vklongpoll.OptionsFromJSON([]byte(c)); err == nil {
+				t.Errorf("expected error for %s", c)
+			}
+		}
+	})
+
+	t.Run("trailing whitespace", func(t *testing.T) {
+		if _, err := vklongpoll.OptionsFromJSON([]byte("{\"wait\": \"25s\"}\n\t ")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("dump and load same options", func(t *testing.T) {
+		opt := vklongpoll.BuildOptions(
+			vklongpoll.WithWait(time.Minute),
+			vklongpoll.WithModeSum(vklongpoll.Extended, vklongpoll.ExtraFields),
+			vklongpoll.WithParams(url.Values{"foo": {"bar"}}),
+		)
+
+		dump, err := vklongpoll.DumpOptions(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := vklongpoll.OptionsFromJSON(dump)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(opt, loaded) {
+			t.Errorf("expected options %v but got %v from %s", opt, loaded, dump)
+		}
+	})
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Run("all variables", func(t *testing.T) {
+		t.Setenv("VKLP_WAIT", "25")
+		t.Setenv("VKLP_VERSION", "10")
+		t.Setenv("VKLP_MODE", "attachments,pts")
+		t.Setenv("VKLP_UPDATES_JSON_PATH", "response.updates")
+		t.Setenv("VKLP_PARAMS", "foo=bar")
+		t.Setenv("VKLP_NEGOTIATE_VERSION", "true")
+
+		opt, err := vklongpoll.OptionsFromEnv("VKLP")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedOpt := vklongpoll.NewOptions()
+		expectedOpt.Wait = 25 * time.Second
+		expectedOpt.Version = 10
+		expectedOpt.Mode = vklongpoll.Attachments | vklongpoll.ReturnPts
+		expectedOpt.UpdatesJsonPath = []string{"response", "updates"}
+		expectedOpt.Params = url.Values{"foo": {"bar"}}
+		expectedOpt.NegotiateVersion = true
+
+		if !reflect.DeepEqual(opt, expectedOpt) {
+			t.Errorf("expected options %v but got %v", expectedOpt, opt)
+		}
+	})
+
+	t.Run("unknown variable", func(t *testing.T) {
+		t.Setenv("VKLP_WIAT", "25")
+		if _, err := vklongpoll.OptionsFromEnv("VKLP_"); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("VKLP_ZERO_COPY", "maybe")
+		if _, err := vklongpoll.OptionsFromEnv("VKLP"); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package vklongpoll
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// Отложенные команды управления соединением
+// Применяются в начале следующего запроса, чтобы не ждать завершения текущего
+type control struct {
+	paused   bool
+	resumeCh chan struct{} // Закрывается при Resume
+	ts       *int64        // Новое значение ts (SetTs)
+	refresh  bool          // Обновить данные сервера, сохранив ts (ForceServerRefresh)
+	reset    bool          // Обновить данные сервера вместе с ts (ResetToLatest)
+	mx       sync.Mutex
+}
+
+// Приостанавливает получение событий: следующие вызовы Recv ждут Resume
+// Текущий запрос (если есть) завершается как обычно
+func (v *VkLongPoll) Pause() {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	if !v.control.paused {
+		v.control.paused = true
+		v.control.resumeCh = make(chan struct{})
+	}
+}
+
+// Возобновляет получение событий после Pause
+func (v *VkLongPoll) Resume() {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	if v.control.paused {
+		v.control.paused = false
+		close(v.control.resumeCh)
+	}
+}
+
+// Проверяет, приостановлено ли соединение
+func (v *VkLongPoll) Paused() bool {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	return v.control.paused
+}
+
+// Запрашивает новые данные сервера перед следующим запросом, значение ts при этом сохраняется
+func (v *VkLongPoll) ForceServerRefresh() {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	v.control.refresh = true
+}
+
+// Устанавливает ts для следующего запроса, например, чтобы повторить события с более старого ts
+// или пропустить пачку событий, на которой падает обработчик
+// До первого запроса позволяет продолжить с сохраненного ts
+func (v *VkLongPoll) SetTs(ts int64) {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	v.control.ts = &ts
+	v.control.reset = false
+}
+
+// Пропускает все накопившиеся события: перед следующим запросом данные сервера
+// запрашиваются заново, и ts берется из ответа ServerUpdater
+func (v *VkLongPoll) ResetToLatest() {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	v.control.reset = true
+	v.control.ts = nil
+}
+
+// Ждет Resume, если соединение приостановлено
+func (v *VkLongPoll) waitResume(ctx context.Context) error {
+	v.control.mx.Lock()
+	paused, resumeCh := v.control.paused, v.control.resumeCh
+	v.control.mx.Unlock()
+
+	if !paused {
+		return nil
+	}
+
+	select {
+	case <-resumeCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Возвращает команды, которые не удалось применить, чтобы повторить их перед следующим запросом
+// Команды, заданные за это время заново, не перезаписываются
+func (v *VkLongPoll) restoreControl(ts *int64, refresh, reset bool) {
+	v.control.mx.Lock()
+	defer v.control.mx.Unlock()
+
+	if v.control.ts == nil && !v.control.reset {
+		v.control.ts, v.control.reset = ts, reset
+	}
+	v.control.refresh = v.control.refresh || refresh
+}
+
+// Применяет отложенные команды, вызывается под блокировкой mx перед запросом
+// Если применить команды не удалось, они остаются отложенными
+func (v *VkLongPoll) applyControl(ctx context.Context, opt *VkLongPollOptions) error {
+	v.control.mx.Lock()
+	ts, refresh, reset := v.control.ts, v.control.refresh, v.control.reset
+	v.control.ts, v.control.refresh, v.control.reset = nil, false, false
+	v.control.mx.Unlock()
+
+	if refresh || reset {
+		currentTs := v.Ts
+		hadServer := v.serverUrl != nil
+
+		// Текущие данные передаются как отклоненные, иначе SharedServerUpdater вернет их же из кеша
+		var rejected *ServerCredentials
+		if hadServer {
+			rejected = &ServerCredentials{
+				Ts:        v.Ts,
+				ServerURL: v.serverUrl,
+				Key:       v.key,
+			}
+		}
+
+		creds, err := v.updateServer(ctx, opt, rejected)
+		if err != nil {
+			v.restoreControl(ts, refresh, reset)
+			return err
+		}
+
+		if reset {
+			v.Ts = creds.Ts
+		} else if hadServer {
+			v.Ts = currentTs
+		}
+	}
+
+	if ts != nil {
+		// Без данных сервера recvResponse запросил бы их и перезаписал ts
+		if v.serverUrl == nil {
+			if _, err := v.updateServer(ctx, opt, nil); err != nil {
+				v.restoreControl(ts, false, false)
+				return err
+			}
+		}
+		v.Ts = *ts
+	}
+
+	return nil
+}
+
+// Ответ AdminHandler
+type adminResponse struct {
+	State
+	Key    string `json:"key,omitempty"` // Ключ не раскрывается, только признак его наличия
+	Paused bool   `json:"paused"`
+}
+
+// Возвращает HTTP обработчик для управления соединением во время работы
+// Путь определяется по последнему сегменту, поэтому обработчик можно подключить с любым префиксом:
+//
+//	GET  .../state          - состояние соединения в JSON (ключ скрыт)
+//	POST .../pause          - Pause
+//	POST .../resume         - Resume
+//	POST .../refresh        - ForceServerRefresh
+//	POST .../reset          - ResetToLatest
+//	POST .../ts?ts=123      - SetTs
+//
+// В ответ на любую команду возвращается состояние соединения.
+// Обработчик не проверяет доступ, его нужно защищать отдельно
+func AdminHandler(lp *VkLongPoll) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := path.Base(r.URL.Path)
+
+		if action != "state" && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch action {
+		case "state":
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+		case "pause":
+			lp.Pause()
+		case "resume":
+			lp.Resume()
+		case "refresh":
+			lp.ForceServerRefresh()
+		case "reset":
+			lp.ResetToLatest()
+		case "ts":
+			ts, err := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid ts: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			lp.SetTs(ts)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		res := adminResponse{
+			State:  lp.State(),
+			Paused: lp.Paused(),
+		}
+
+		if res.Server != "" {
+			res.Key = "REDACTED"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
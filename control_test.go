@@ -0,0 +1,279 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestControl(t *testing.T) {
+	requestedTs := make(chan int64, 1)
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, _ := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+		requestedTs <- ts
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ts":      strconv.FormatInt(ts+1, 10),
+			"updates": []interface{}{},
+		})
+	}))
+
+	defer longPollServer.Close()
+
+	var serverUpdates int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&serverUpdates, 1)
+		json.NewEncoder(w).Encode(getServerResponse(longPollServer.URL))
+	}))
+
+	defer apiServer.Close()
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	serverUpdater := vklongpoll.WithServerUpdater(vklongpoll.UniversalServerUpdater(request.New(), executor.New()))
+
+	lp := vklongpoll.New()
+	recv := func(wantTs int64, wantServerUpdates int32) {
+		t.Helper()
+
+		if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		if ts := <-requestedTs; ts != wantTs {
+			t.Errorf("expected request with ts %d but got %d", wantTs, ts)
+		}
+
+		if n := atomic.LoadInt32(&serverUpdates); n != wantServerUpdates {
+			t.Errorf("expected %d server updates but got %d", wantServerUpdates, n)
+		}
+	}
+
+	recv(1, 1)
+
+	lp.SetTs(100)
+	recv(100, 1)
+
+	lp.ForceServerRefresh()
+	recv(101, 2)
+
+	lp.ResetToLatest()
+	recv(1, 3)
+
+	admin := adminRequester(t, lp)
+
+	admin("POST", "/admin/pause", http.StatusOK)
+	if !lp.Paused() {
+		t.Fatal("expected long poll to be paused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := lp.Recv(ctx, serverUpdater); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected paused Recv to wait for resume but got %v", err)
+	}
+
+	admin("GET", "/admin/pause", http.StatusMethodNotAllowed)
+	admin("POST", "/admin/ts?ts=abc", http.StatusBadRequest)
+	admin("POST", "/admin/ts?ts=50", http.StatusOK)
+	admin("POST", "/admin/resume", http.StatusOK)
+	recv(50, 3)
+
+	body := admin("GET", "/admin/state", http.StatusOK)
+	if strings.Contains(body, "longpoll_server_key") {
+		t.Errorf("state exposes server key: %s", body)
+	}
+
+	state := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	if state["key"] != "REDACTED" || state["ts"] != float64(51) || state["paused"] != false {
+		t.Errorf("unexpected state: %s", body)
+	}
+
+	if body := admin("HEAD", "/admin/state", http.StatusOK); body != "" {
+		t.Errorf("expected empty body for HEAD but got %s", body)
+	}
+}
+
+func TestControlRetry(t *testing.T) {
+	requestedTs := make(chan int64, 1)
+	unblock := make(chan struct{})
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, _ := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+		if ts == 0 {
+			// Запрос держится, пока тест не отпустит его
+			<-unblock
+		}
+		requestedTs <- ts
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ts":      strconv.FormatInt(ts+1, 10),
+			"updates": []interface{}{},
+		})
+	}))
+
+	defer longPollServer.Close()
+
+	serverUrl, _ := url.Parse(longPollServer.URL)
+	var failUpdates int32
+	serverUpdater := vklongpoll.WithServerUpdater(func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		if atomic.LoadInt32(&failUpdates) != 0 {
+			return nil, errors.New("server update failed")
+		}
+		return &vklongpoll.ServerCredentials{ServerURL: serverUrl, Key: "key"}, nil
+	})
+
+	t.Run("commands survive failed server update", func(t *testing.T) {
+		lp := vklongpoll.New()
+
+		atomic.StoreInt32(&failUpdates, 1)
+		lp.SetTs(10)
+		if _, err := lp.Recv(context.Background(), serverUpdater); err == nil {
+			t.Fatal("expected server update error")
+		}
+
+		atomic.StoreInt32(&failUpdates, 0)
+		if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		if ts := <-requestedTs; ts != 10 {
+			t.Errorf("expected request with ts 10 but got %d", ts)
+		}
+
+		atomic.StoreInt32(&failUpdates, 1)
+		lp.ForceServerRefresh()
+		if _, err := lp.Recv(context.Background(), serverUpdater); err == nil {
+			t.Fatal("expected server update error")
+		}
+
+		// Обновление сервера повторяется, а ts сохраняется
+		atomic.StoreInt32(&failUpdates, 0)
+		if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		if ts := <-requestedTs; ts != 11 {
+			t.Errorf("expected request with ts 11 but got %d", ts)
+		}
+	})
+
+	t.Run("pause stops Recv waiting for another one", func(t *testing.T) {
+		lp := vklongpoll.New()
+		recvErr := make(chan error, 2)
+		recv := func(ctx context.Context) {
+			_, err := lp.Recv(ctx, serverUpdater)
+			recvErr <- err
+		}
+
+		go recv(context.Background())
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+
+		// Второй Recv уже прошел проверку паузы и ждет первый
+		go recv(ctx)
+		time.Sleep(50 * time.Millisecond)
+
+		lp.Pause()
+		close(unblock)
+
+		if err := <-recvErr; err != nil {
+			t.Fatal(err)
+		}
+		<-requestedTs
+
+		if err := <-recvErr; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected paused Recv to wait for resume but got %v", err)
+		}
+
+		select {
+		case ts := <-requestedTs:
+			t.Errorf("unexpected request with ts %d while paused", ts)
+		default:
+		}
+	})
+}
+
+func TestControlSharedServerUpdater(t *testing.T) {
+	requestedTs := make(chan int64, 1)
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, _ := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+		requestedTs <- ts
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ts":      strconv.FormatInt(ts+1, 10),
+			"updates": []interface{}{},
+		})
+	}))
+
+	defer longPollServer.Close()
+
+	serverUrl, _ := url.Parse(longPollServer.URL)
+	var calls int64
+	serverUpdater := vklongpoll.WithServerUpdater(vklongpoll.SharedServerUpdater(func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		call := atomic.AddInt64(&calls, 1)
+		return &vklongpoll.ServerCredentials{
+			Ts:        call * 100,
+			ServerURL: serverUrl,
+			Key:       "key" + strconv.FormatInt(call, 10),
+		}, nil
+	}, time.Hour))
+
+	lp := vklongpoll.New()
+	recv := func(wantTs int64, wantCalls int64) {
+		t.Helper()
+
+		if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		if ts := <-requestedTs; ts != wantTs {
+			t.Errorf("expected request with ts %d but got %d", wantTs, ts)
+		}
+
+		if got := atomic.LoadInt64(&calls); got != wantCalls {
+			t.Errorf("expected %d server updates but got %d", wantCalls, got)
+		}
+	}
+
+	recv(100, 1)
+
+	// Кеш не должен подменять новые данные сервера
+	lp.ForceServerRefresh()
+	recv(101, 2)
+
+	lp.ResetToLatest()
+	recv(300, 3)
+}
+
+// Выполняет запросы к AdminHandler и проверяет код ответа
+func adminRequester(t *testing.T, lp *vklongpoll.VkLongPoll) func(method, path string, wantStatus int) string {
+	handler := http.StripPrefix("/admin", vklongpoll.AdminHandler(lp))
+
+	return func(method, path string, wantStatus int) string {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		if w.Code != wantStatus {
+			t.Errorf("%s %s: expected status %d but got %d", method, path, wantStatus, w.Code)
+		}
+
+		return w.Body.String()
+	}
+}
@@ -0,0 +1,167 @@
+package vklongpoll
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/buger/jsonparser"
+)
+
+// Ошибка, возвращаемая, если ответ сервера больше, чем VkLongPollOptions.MaxResponseBytes
+var ErrResponseTooLarge = errors.New("long poll response too large")
+
+// Пул буферов для чтения ответов Long Poll сервера
+var responseBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Берет буфер из пула
+func getResponseBuffer() *bytes.Buffer {
+	return responseBufferPool.Get().(*bytes.Buffer)
+}
+
+// Возвращает буфер в пул
+func putResponseBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	responseBufferPool.Put(buf)
+}
+
+// Читает тело ответа в буфер, ограничивая его размер maxBytes (0 - без ограничений)
+func readResponse(buf *bytes.Buffer, body io.Reader, maxBytes int64) error {
+	if maxBytes > 0 {
+		body = io.LimitReader(body, maxBytes+1)
+	}
+
+	if _, err := buf.ReadFrom(body); err != nil {
+		return err
+	}
+
+	if maxBytes > 0 && int64(buf.Len()) > maxBytes {
+		return ErrResponseTooLarge
+	}
+
+	return nil
+}
+
+// Общие поля ответа Long Poll сервера
+type pollResponse struct {
+	failed     int64
+	ts         []byte
+	pts        *Pts
+	updates    []byte
+	minVersion int64
+	maxVersion int64
+	hasTs      bool
+	hasUpdates bool
+}
+
+// Индексы путей для jsonparser.EachKey
+const (
+	pollResponseFailed = iota
+	pollResponseTs
+	pollResponsePts
+	pollResponseUpdates
+	pollResponseMinVersion
+	pollResponseMaxVersion
+)
+
+// Разбирает ответ сервера за один проход по JSON
+func parsePollResponse(body []byte, updatesPath []string) pollResponse {
+	res := pollResponse{}
+
+	jsonparser.EachKey(body, func(idx int, value []byte, dataType jsonparser.ValueType, err error) {
+		if err != nil {
+			return
+		}
+
+		switch idx {
+		case pollResponseFailed:
+			res.failed, _ = jsonparser.ParseInt(value)
+		case pollResponseTs:
+			res.ts = value
+			res.hasTs = true
+		case pollResponsePts:
+			pts, err := strconv.ParseInt(string(value), 10, 64)
+			if err == nil {
+				res.pts = (*Pts)(&pts)
+			}
+		case pollResponseUpdates:
+			res.updates = value
+			res.hasUpdates = true
+		case pollResponseMinVersion:
+			res.minVersion, _ = jsonparser.ParseInt(value)
+		case pollResponseMaxVersion:
+			res.maxVersion, _ = jsonparser.ParseInt(value)
+		}
+	}, []string{"failed"}, []string{"ts"}, []string{"pts"}, updatesPath, []string{"min_version"}, []string{"max_version"})
+
+	return res
+}
+
+// Возвращает значение ts из ответа (число или строка)
+func (r *pollResponse) getTs() (int64, error) {
+	if !r.hasTs {
+		return 0, errors.New("ts not found in response")
+	}
+	return strconv.ParseInt(string(r.ts), 10, 64)
+}
+
+// Разбивает массив событий на отдельные события
+// События ссылаются на исходное тело ответа, строки сохраняются как JSON токены вместе с кавычками
+func (r *pollResponse) getUpdates() []Update {
+	updates := []Update{}
+
+	jsonparser.ArrayEach(r.updates, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if dataType == jsonparser.String {
+			value = rawString(r.updates, value, offset)
+		}
+		updates = append(updates, value)
+	})
+
+	return updates
+}
+
+// Возвращает строковое значение вместе с окружающими его кавычками
+// Для строк jsonparser.ArrayEach передает offset, равный концу токена минус длина значения без кавычек
+func rawString(data []byte, value []byte, offset int) []byte {
+	start, end := offset-2, offset+len(value)
+	if start < 0 || end > len(data) || data[start] != '"' || data[end-1] != '"' {
+		return []byte(`"` + string(value) + `"`)
+	}
+	return data[start:end]
+}
+
+// Возвращает целое число из элемента index события пользовательского Long Poll
+func updateInt(u Update, index int) (int64, bool) {
+	value, err := jsonparser.GetInt(u, "["+strconv.Itoa(index)+"]")
+	return value, err == nil
+}
+
+// Возвращает строку из элемента index события пользовательского Long Poll
+func updateString(u Update, index int) string {
+	value, err := jsonparser.GetString(u, "["+strconv.Itoa(index)+"]")
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// Возвращает копию JSON значения из элемента index события пользовательского Long Poll
+func updateRaw(u Update, index int) []byte {
+	value, dataType, _, err := jsonparser.Get(u, "["+strconv.Itoa(index)+"]")
+	if err != nil {
+		return nil
+	}
+
+	if dataType == jsonparser.String {
+		// Get возвращает строку без кавычек, но с экранированием
+		return []byte(`"` + string(value) + `"`)
+	}
+
+	return append([]byte(nil), value...)
+}
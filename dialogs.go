@@ -0,0 +1,249 @@
+package vklongpoll
+
+import (
+	"sort"
+	"sync"
+)
+
+// Коды событий пользовательского Long Poll
+const (
+	EventMessageFlagsSet   = 2  // Установка флагов сообщения
+	EventMessageFlagsReset = 3  // Сброс флагов сообщения
+	EventMessageNew        = 4  // Новое сообщение
+	EventMessageEdit       = 5  // Редактирование сообщения
+	EventInRead            = 6  // Прочтение входящих сообщений
+	EventOutRead           = 7  // Прочтение исходящих сообщений
+	EventUnreadCounter     = 80 // Изменение счетчика непрочитанных
+)
+
+// Флаг исходящего сообщения
+const messageFlagOutbox = 2
+
+// Состояние диалога
+type Dialog struct {
+	PeerID        int64 `json:"peer_id"`
+	LastMessageID int64 `json:"last_message_id"` // Идентификатор последнего сообщения
+	InRead        int64 `json:"in_read"`         // Последнее прочитанное входящее сообщение
+	OutRead       int64 `json:"out_read"`        // Последнее исходящее сообщение, прочитанное собеседником
+	Unread        int   `json:"unread"`          // Количество непрочитанных входящих сообщений
+}
+
+// Изменение состояния диалогов
+type DialogChange struct {
+	Code    int    // Код события, вызвавшего изменение
+	Dialog  Dialog // Новое состояние диалога (пустое для события 80)
+	Counter int    // Общий счетчик непрочитанных диалогов
+}
+
+// Снимок состояния DialogTracker для восстановления после перезапуска
+type DialogsSnapshot struct {
+	Dialogs   []Dialog          `json:"dialogs"`
+	Counter   int               `json:"counter"`
+	UnreadIDs map[int64][]int64 `json:"unread_ids,omitempty"` // Непрочитанные входящие сообщения по PeerID, нужны для частичного прочтения
+}
+
+// Состояние диалогов, собранное из событий 4, 6, 7 и 80 пользовательского Long Poll
+// Безопасно использовать из нескольких горутин
+type DialogTracker struct {
+	dialogs     map[int64]*trackedDialog
+	counter     int
+	subscribers subscribers[DialogChange]
+	mx          sync.RWMutex
+}
+
+type trackedDialog struct {
+	Dialog
+	unreadIDs []int64 // Идентификаторы непрочитанных входящих сообщений, полученных из событий
+}
+
+// Создает пустой DialogTracker
+func NewDialogTracker() *DialogTracker {
+	return &DialogTracker{
+		dialogs: map[int64]*trackedDialog{},
+	}
+}
+
+// Учитывает события, остальные события пропускаются
+func (t *DialogTracker) Handle(updates []Update) {
+	changes := []DialogChange{}
+
+	t.mx.Lock()
+	for _, u := range updates {
+		if change, ok := t.handle(u); ok {
+			changes = append(changes, change)
+		}
+	}
+	t.mx.Unlock()
+
+	t.subscribers.notify(changes...)
+}
+
+// Учитывает одно событие, вызывается под блокировкой
+func (t *DialogTracker) handle(u Update) (DialogChange, bool) {
+	code, ok := updateInt(u, 0)
+	if !ok {
+		return DialogChange{}, false
+	}
+
+	switch code {
+	case EventMessageNew:
+		messageID, ok1 := updateInt(u, 1)
+		flags, ok2 := updateInt(u, 2)
+		peerID, ok3 := updateInt(u, 3)
+		if !ok1 || !ok2 || !ok3 {
+			return DialogChange{}, false
+		}
+
+		dialog := t.dialog(peerID)
+		// Событие уже учтено, например, пришло повторно после восстановления ts
+		if messageID <= dialog.LastMessageID {
+			return DialogChange{}, false
+		}
+		dialog.LastMessageID = messageID
+
+		if flags&messageFlagOutbox == 0 && messageID > dialog.InRead {
+			dialog.unreadIDs = append(dialog.unreadIDs, messageID)
+			dialog.Unread++
+		}
+
+		return t.change(int(code), dialog), true
+	case EventInRead, EventOutRead:
+		peerID, ok1 := updateInt(u, 1)
+		localID, ok2 := updateInt(u, 2)
+		if !ok1 || !ok2 {
+			return DialogChange{}, false
+		}
+
+		dialog := t.dialog(peerID)
+		if code == EventOutRead {
+			if localID > dialog.OutRead {
+				dialog.OutRead = localID
+			}
+			return t.change(int(code), dialog), true
+		}
+
+		if localID > dialog.InRead {
+			dialog.InRead = localID
+		}
+		dialog.markRead()
+
+		return t.change(int(code), dialog), true
+	case EventUnreadCounter:
+		counter, ok := updateInt(u, 1)
+		if !ok {
+			return DialogChange{}, false
+		}
+
+		t.counter = int(counter)
+		return DialogChange{Code: int(code), Counter: t.counter}, true
+	}
+
+	return DialogChange{}, false
+}
+
+// Пересчитывает непрочитанные сообщения после изменения InRead
+func (d *trackedDialog) markRead() {
+	if d.InRead >= d.LastMessageID {
+		d.unreadIDs = nil
+		d.Unread = 0
+		return
+	}
+
+	unreadIDs := d.unreadIDs[:0]
+	for _, id := range d.unreadIDs {
+		if id > d.InRead {
+			unreadIDs = append(unreadIDs, id)
+		}
+	}
+
+	d.Unread -= len(d.unreadIDs) - len(unreadIDs)
+	if d.Unread < len(unreadIDs) {
+		d.Unread = len(unreadIDs)
+	}
+	d.unreadIDs = unreadIDs
+}
+
+// Возвращает диалог, создавая его при необходимости
+func (t *DialogTracker) dialog(peerID int64) *trackedDialog {
+	dialog, ok := t.dialogs[peerID]
+	if !ok {
+		dialog = &trackedDialog{Dialog: Dialog{PeerID: peerID}}
+		t.dialogs[peerID] = dialog
+	}
+	return dialog
+}
+
+func (t *DialogTracker) change(code int, dialog *trackedDialog) DialogChange {
+	return DialogChange{Code: code, Dialog: dialog.Dialog, Counter: t.counter}
+}
+
+// Возвращает состояние диалога
+func (t *DialogTracker) Dialog(peerID int64) (Dialog, bool) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	dialog, ok := t.dialogs[peerID]
+	if !ok {
+		return Dialog{}, false
+	}
+	return dialog.Dialog, true
+}
+
+// Возвращает общий счетчик непрочитанных диалогов (событие 80)
+func (t *DialogTracker) Counter() int {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	return t.counter
+}
+
+// Возвращает снимок состояния, отсортированный по PeerID
+func (t *DialogTracker) Snapshot() DialogsSnapshot {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	snapshot := DialogsSnapshot{
+		Dialogs: make([]Dialog, 0, len(t.dialogs)),
+		Counter: t.counter,
+	}
+
+	for _, dialog := range t.dialogs {
+		snapshot.Dialogs = append(snapshot.Dialogs, dialog.Dialog)
+
+		if len(dialog.unreadIDs) != 0 {
+			if snapshot.UnreadIDs == nil {
+				snapshot.UnreadIDs = map[int64][]int64{}
+			}
+			snapshot.UnreadIDs[dialog.PeerID] = append([]int64(nil), dialog.unreadIDs...)
+		}
+	}
+
+	sort.Slice(snapshot.Dialogs, func(i, j int) bool {
+		return snapshot.Dialogs[i].PeerID < snapshot.Dialogs[j].PeerID
+	})
+
+	return snapshot
+}
+
+// Заменяет состояние снимком, например, сохраненным перед перезапуском или полученным через API
+// Без UnreadIDs (снимок из API) частичное прочтение не уменьшает Unread до следующего полного прочтения.
+// Подписчики не уведомляются
+func (t *DialogTracker) Restore(snapshot DialogsSnapshot) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.dialogs = make(map[int64]*trackedDialog, len(snapshot.Dialogs))
+	for _, dialog := range snapshot.Dialogs {
+		t.dialogs[dialog.PeerID] = &trackedDialog{
+			Dialog:    dialog,
+			unreadIDs: append([]int64(nil), snapshot.UnreadIDs[dialog.PeerID]...),
+		}
+	}
+	t.counter = snapshot.Counter
+}
+
+// Подписывает fn на изменения состояния, возвращает функцию отписки
+// fn вызывается из горутины, вызвавшей Handle, после применения всех событий
+func (t *DialogTracker) Subscribe(fn func(change DialogChange)) (unsubscribe func()) {
+	return t.subscribers.add(fn)
+}
@@ -0,0 +1,117 @@
+package vklongpoll_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestDialogTracker(t *testing.T) {
+	tracker := vklongpoll.NewDialogTracker()
+
+	changes := []vklongpoll.DialogChange{}
+	unsubscribe := tracker.Subscribe(func(change vklongpoll.DialogChange) {
+		changes = append(changes, change)
+	})
+
+	tracker.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[4, 10, 1, 123, 1700000000, "hi"]`),
+		vklongpoll.Update(`[4, 11, 1, 123, 1700000001, "are you there?"]`),
+		vklongpoll.Update(`[4, 12, 3, 123, 1700000002, "yes"]`),
+		vklongpoll.Update(`[4, 13, 1, 123, 1700000003, "ok"]`),
+		vklongpoll.Update(`[61, 123, 1]`),
+		vklongpoll.Update(`[80, 2, 0]`),
+	})
+
+	dialog, ok := tracker.Dialog(123)
+	want := vklongpoll.Dialog{PeerID: 123, LastMessageID: 13, Unread: 3}
+	if !ok || dialog != want {
+		t.Errorf("expected %+v but got %+v", want, dialog)
+	}
+
+	if tracker.Counter() != 2 {
+		t.Errorf("expected counter 2 but got %d", tracker.Counter())
+	}
+
+	if len(changes) != 5 || changes[4].Code != vklongpoll.EventUnreadCounter || changes[4].Counter != 2 {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+
+	// Повторно полученное событие не учитывается
+	tracker.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 13, 1, 123, 1700000003, "ok"]`)})
+
+	if dialog, _ := tracker.Dialog(123); dialog != want {
+		t.Errorf("expected %+v after redelivery but got %+v", want, dialog)
+	}
+
+	if len(changes) != 5 {
+		t.Errorf("expected no changes after redelivery but got %+v", changes[5:])
+	}
+
+	tracker.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[6, 123, 11]`),
+		vklongpoll.Update(`[7, 123, 12]`),
+	})
+
+	dialog, _ = tracker.Dialog(123)
+	want = vklongpoll.Dialog{PeerID: 123, LastMessageID: 13, InRead: 11, OutRead: 12, Unread: 1}
+	if dialog != want {
+		t.Errorf("expected %+v but got %+v", want, dialog)
+	}
+
+	unsubscribe()
+	tracker.Handle([]vklongpoll.Update{vklongpoll.Update(`[6, 123, 13]`)})
+
+	if len(changes) != 7 {
+		t.Errorf("expected no changes after unsubscribe but got %+v", changes[7:])
+	}
+
+	if dialog, _ = tracker.Dialog(123); dialog.Unread != 0 {
+		t.Errorf("expected dialog to be read but got %+v", dialog)
+	}
+
+	data, err := json.Marshal(tracker.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := vklongpoll.DialogsSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := vklongpoll.NewDialogTracker()
+	restored.Restore(snapshot)
+
+	if !reflect.DeepEqual(restored.Snapshot(), tracker.Snapshot()) {
+		t.Errorf("expected restored snapshot %+v but got %+v", tracker.Snapshot(), restored.Snapshot())
+	}
+
+	restored.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 14, 1, 123, 1700000004, "again"]`)})
+	if dialog, _ := restored.Dialog(123); dialog.Unread != 1 || dialog.LastMessageID != 14 {
+		t.Errorf("unexpected dialog after restore: %+v", dialog)
+	}
+
+	// Частичное прочтение после перезапуска учитывает сохраненные непрочитанные сообщения
+	restored.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 15, 1, 123, 1700000005, "hello?"]`)})
+
+	data, err = json.Marshal(restored.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot = vklongpoll.DialogsSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := vklongpoll.NewDialogTracker()
+	restarted.Restore(snapshot)
+	restarted.Handle([]vklongpoll.Update{vklongpoll.Update(`[6, 123, 14]`)})
+
+	if dialog, _ := restarted.Dialog(123); dialog.Unread != 1 || dialog.InRead != 14 {
+		t.Errorf("unexpected dialog after partial read: %+v", dialog)
+	}
+}
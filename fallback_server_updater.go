@@ -0,0 +1,95 @@
+package vklongpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerUpdater с именем для FallbackServerUpdater
+type NamedServerUpdater struct {
+	Name    string
+	Updater ServerUpdater
+}
+
+// Цепочка обработчиков для FallbackServerUpdater
+type fallbackChain struct {
+	updaters     []NamedServerUpdater
+	cooldown     time.Duration
+	last         int       // Индекс обработчика, который последним получил данные
+	primaryRetry time.Time // Время, после которого снова пробуем первый обработчик
+	mx           sync.Mutex
+}
+
+// Пробует обработчики по порядку, пока один из них не вернет данные сервера
+// Запоминает последний успешный обработчик и начинает с него, а первый (основной) обработчик
+// пробует снова только через cooldown. Имя обработчика записывается в ServerCredentials.Source
+// и доступно через VkLongPoll.State().CredentialsSource
+func FallbackServerUpdater(cooldown time.Duration, updaters ...NamedServerUpdater) ServerUpdater {
+	chain := &fallbackChain{
+		updaters: updaters,
+		cooldown: cooldown,
+	}
+
+	return chain.get
+}
+
+// Запрашивает данные сервера у обработчиков цепочки
+func (f *fallbackChain) get(ctx context.Context) (*ServerCredentials, error) {
+	if len(f.updaters) == 0 {
+		return nil, errors.New("no server updaters in fallback chain")
+	}
+
+	errs := []string{}
+	for _, i := range f.order() {
+		updater := f.updaters[i]
+
+		creds, err := updater.Updater(ctx)
+		if err == nil {
+			f.success(i)
+			creds.Source = updater.Name
+			return creds, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		errs = append(errs, updater.Name+": "+err.Error())
+	}
+
+	return nil, fmt.Errorf("all server updaters failed: %s", strings.Join(errs, "; "))
+}
+
+// Возвращает порядок обработчиков: с последнего успешного, если основной еще на паузе, иначе - с основного
+func (f *fallbackChain) order() []int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	start := 0
+	if f.last != 0 && time.Now().Before(f.primaryRetry) {
+		start = f.last
+	}
+
+	order := make([]int, 0, len(f.updaters))
+	for i := range f.updaters {
+		order = append(order, (start+i)%len(f.updaters))
+	}
+
+	return order
+}
+
+// Запоминает успешный обработчик
+func (f *fallbackChain) success(i int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	now := time.Now()
+	if i != 0 && (f.last != i || !now.Before(f.primaryRetry)) {
+		f.primaryRetry = now.Add(f.cooldown)
+	}
+	f.last = i
+}
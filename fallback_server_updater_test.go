@@ -0,0 +1,94 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestFallbackServerUpdater(t *testing.T) {
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ts":2,"updates":[]}`))
+	}))
+
+	defer longPollServer.Close()
+
+	primaryFails := true
+	var primaryCalls, backupCalls int
+
+	primary := func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		primaryCalls++
+		if primaryFails {
+			return nil, errors.New("gateway unavailable")
+		}
+		serverUrl, _ := url.Parse(longPollServer.URL)
+		return &vklongpoll.ServerCredentials{Ts: 1, ServerURL: serverUrl, Key: "primary"}, nil
+	}
+
+	backup := func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		backupCalls++
+		serverUrl, _ := url.Parse(longPollServer.URL)
+		return &vklongpoll.ServerCredentials{Ts: 1, ServerURL: serverUrl, Key: "backup"}, nil
+	}
+
+	cooldown := 20 * time.Millisecond
+	chain := vklongpoll.FallbackServerUpdater(cooldown,
+		vklongpoll.NamedServerUpdater{Name: "gateway", Updater: primary},
+		vklongpoll.NamedServerUpdater{Name: "direct", Updater: backup},
+	)
+
+	t.Run("falls back and reports source", func(t *testing.T) {
+		lp := vklongpoll.New()
+		if _, err := lp.Recv(context.Background(), vklongpoll.WithServerUpdater(chain)); err != nil {
+			t.Fatal(err)
+		}
+
+		if source := lp.State().CredentialsSource; source != "direct" {
+			t.Errorf("expected credentials source %q but got %q", "direct", source)
+		}
+	})
+
+	t.Run("remembers last successful updater", func(t *testing.T) {
+		primaryCalls = 0
+		if _, err := chain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if primaryCalls != 0 {
+			t.Errorf("expected primary skipped during cool-down but got %d calls", primaryCalls)
+		}
+	})
+
+	t.Run("retries primary after cool-down", func(t *testing.T) {
+		primaryFails = false
+		time.Sleep(cooldown)
+
+		creds, err := chain(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if creds.Source != "gateway" {
+			t.Errorf("expected credentials source %q but got %q", "gateway", creds.Source)
+		}
+	})
+
+	t.Run("all updaters failed", func(t *testing.T) {
+		failing := vklongpoll.FallbackServerUpdater(cooldown, vklongpoll.NamedServerUpdater{
+			Name: "failing",
+			Updater: func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+				return nil, errors.New("failed")
+			},
+		})
+
+		if _, err := failing(context.Background()); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+}
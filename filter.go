@@ -0,0 +1,697 @@
+package vklongpoll
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+// Фильтр событий, скомпилированный из выражения
+//
+// Выражение проверяется для каждого события, события, для которых оно ложно, отбрасываются:
+//
+//	code in (4, 5) && !flags.outbox && peer.kind == "chat"
+//	type == "message_new" && object.message.text =~ "^/"
+//
+// Поля событий пользовательского Long Poll (массивы):
+//
+//	code        - код события (элемент 0)
+//	message_id  - идентификатор сообщения (элемент 1)
+//	flags       - флаги сообщения (элемент 2), flags.<имя> - отдельный флаг (см. messageFlags)
+//	peer_id     - идентификатор назначения (элемент 3)
+//	peer.kind   - тип назначения: "user", "chat" или "group"
+//	timestamp   - время отправки (элемент 4)
+//	text        - текст сообщения (элемент 5)
+//
+// Поля событий Bots Long Poll (объекты): type, event_id, group_id и object.<путь> - любое поле объекта события.
+//
+// Операторы: ||, &&, !, ==, !=, <, <=, >, >=, =~ (регулярное выражение), in (список значений).
+// Значения: числа, строки в двойных кавычках, true, false, null.
+// Отсутствующее в событии поле равно null. Неизвестные поля и несовместимые типы - ошибка компиляции
+type Filter struct {
+	expr  string
+	match func(u Update) filterValue
+}
+
+// Флаги сообщений пользовательского Long Poll для полей flags.<имя>
+var messageFlags = map[string]int64{
+	"unread":      1,
+	"outbox":      2,
+	"replied":     4,
+	"important":   8,
+	"chat":        16,
+	"friends":     32,
+	"spam":        64,
+	"deleted":     128,
+	"fixed":       256,
+	"media":       512,
+	"hidden":      65536,
+	"deleted_all": 131072,
+}
+
+// Смещение peer_id для бесед
+const chatPeerOffset = 2000000000
+
+// Компилирует фильтр из выражения
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{expr: expr}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+
+	return &Filter{expr: expr, match: node.eval}, nil
+}
+
+// То же самое, что CompileFilter, но паникует при ошибке
+// Удобно для выражений, заданных в коде
+func MustCompileFilter(expr string) *Filter {
+	f, err := CompileFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Проверяет, проходит ли событие фильтр
+func (f *Filter) Match(u Update) bool {
+	return f.match(u).truthy()
+}
+
+// Возвращает события, прошедшие фильтр
+// Переиспользует массив updates
+func (f *Filter) Apply(updates []Update) []Update {
+	filtered := updates[:0]
+	for _, u := range updates {
+		if f.Match(u) {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered
+}
+
+// Возвращает исходное выражение
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Возвращает исходное выражение (для конфигурации)
+func (f *Filter) MarshalText() ([]byte, error) {
+	return []byte(f.expr), nil
+}
+
+// Компилирует фильтр из выражения (для конфигурации)
+func (f *Filter) UnmarshalText(text []byte) error {
+	compiled, err := CompileFilter(string(text))
+	if err != nil {
+		return err
+	}
+	*f = *compiled
+	return nil
+}
+
+// Тип значения в выражении
+type valueKind int
+
+const (
+	kindAny valueKind = iota // Тип известен только во время проверки (object.*)
+	kindNull
+	kindNumber
+	kindString
+	kindBool
+	kindJSON // Объект или массив
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case kindNull:
+		return "null"
+	case kindNumber:
+		return "number"
+	case kindString:
+		return "string"
+	case kindBool:
+		return "bool"
+	case kindJSON:
+		return "json"
+	}
+	return "any"
+}
+
+// Значение в выражении
+type filterValue struct {
+	kind valueKind
+	num  float64
+	str  string
+	b    bool
+}
+
+// Приводит значение к логическому
+func (v filterValue) truthy() bool {
+	switch v.kind {
+	case kindBool:
+		return v.b
+	case kindNumber:
+		return v.num != 0
+	case kindString:
+		return v.str != ""
+	case kindJSON:
+		return true
+	}
+	return false
+}
+
+// Сравнивает значения на равенство, значения разных типов не равны
+func (v filterValue) equal(other filterValue) bool {
+	if v.kind != other.kind {
+		return false
+	}
+
+	switch v.kind {
+	case kindNumber:
+		return v.num == other.num
+	case kindString, kindJSON:
+		return v.str == other.str
+	case kindBool:
+		return v.b == other.b
+	}
+	return true
+}
+
+func boolValue(b bool) filterValue {
+	return filterValue{kind: kindBool, b: b}
+}
+
+// Узел скомпилированного выражения
+type filterNode struct {
+	kind valueKind // Тип значения, известный при компиляции
+	eval func(u Update) filterValue
+}
+
+// Возвращает значение по пути в событии
+func jsonValue(u Update, path ...string) filterValue {
+	value, dataType, _, err := jsonparser.Get(u, path...)
+	if err != nil {
+		return filterValue{kind: kindNull}
+	}
+
+	switch dataType {
+	case jsonparser.Number:
+		num, err := jsonparser.ParseFloat(value)
+		if err == nil {
+			return filterValue{kind: kindNumber, num: num}
+		}
+	case jsonparser.String:
+		str, err := jsonparser.ParseString(value)
+		if err == nil {
+			return filterValue{kind: kindString, str: str}
+		}
+	case jsonparser.Boolean:
+		b, err := jsonparser.ParseBoolean(value)
+		if err == nil {
+			return boolValue(b)
+		}
+	case jsonparser.Object, jsonparser.Array:
+		return filterValue{kind: kindJSON, str: string(value)}
+	}
+
+	return filterValue{kind: kindNull}
+}
+
+// Поля событий с фиксированным типом
+var filterFields = map[string]filterNode{
+	"code":       arrayField(0, kindNumber),
+	"message_id": arrayField(1, kindNumber),
+	"flags":      arrayField(2, kindNumber),
+	"peer_id":    arrayField(3, kindNumber),
+	"timestamp":  arrayField(4, kindNumber),
+	"text":       arrayField(5, kindString),
+	"peer.kind": {kind: kindString, eval: func(u Update) filterValue {
+		peerID := jsonValue(u, "[3]")
+		if peerID.kind != kindNumber {
+			return filterValue{kind: kindNull}
+		}
+
+		switch {
+		case peerID.num > chatPeerOffset:
+			return filterValue{kind: kindString, str: "chat"}
+		case peerID.num < 0:
+			return filterValue{kind: kindString, str: "group"}
+		}
+		return filterValue{kind: kindString, str: "user"}
+	}},
+	"type":     objectField("type", kindString),
+	"event_id": objectField("event_id", kindString),
+	"group_id": objectField("group_id", kindNumber),
+}
+
+// Поле события пользовательского Long Poll (элемент массива)
+func arrayField(index int, kind valueKind) filterNode {
+	key := "[" + strconv.Itoa(index) + "]"
+	return filterNode{kind: kind, eval: func(u Update) filterValue {
+		return jsonValue(u, key)
+	}}
+}
+
+// Поле события Bots Long Poll
+func objectField(key string, kind valueKind) filterNode {
+	return filterNode{kind: kind, eval: func(u Update) filterValue {
+		return jsonValue(u, key)
+	}}
+}
+
+// Возвращает узел для поля события
+func compileField(name string) (filterNode, bool) {
+	if field, ok := filterFields[name]; ok {
+		return field, true
+	}
+
+	if strings.HasPrefix(name, "flags.") {
+		flag, ok := messageFlags[strings.TrimPrefix(name, "flags.")]
+		if !ok {
+			return filterNode{}, false
+		}
+
+		flags := filterFields["flags"]
+		return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			value := flags.eval(u)
+			return boolValue(value.kind == kindNumber && int64(value.num)&flag != 0)
+		}}, true
+	}
+
+	if name == "object" || strings.HasPrefix(name, "object.") {
+		path := strings.Split(name, ".")
+		for _, key := range path {
+			if key == "" {
+				return filterNode{}, false
+			}
+		}
+
+		return filterNode{kind: kindAny, eval: func(u Update) filterValue {
+			return jsonValue(u, path...)
+		}}, true
+	}
+
+	return filterNode{}, false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOp
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Разбор выражения фильтра (рекурсивный спуск)
+type filterParser struct {
+	expr   string
+	tokens []filterToken
+	pos    int
+}
+
+// Операторы, от длинных к коротким
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!", "<", ">", "(", ")", ","}
+
+func (p *filterParser) errorf(tok filterToken, format string, args ...interface{}) error {
+	return fmt.Errorf("filter %q: position %d: %s", p.expr, tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// Разбивает выражение на токены
+func (p *filterParser) tokenize() error {
+	s := p.expr
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && (isIdentStart(s[i]) || isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, filterToken{kind: tokenIdent, text: s[start:i], pos: start})
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			start := i
+			i++
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, filterToken{kind: tokenNumber, text: s[start:i], pos: start})
+		case c == '"':
+			start := i
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return p.errorf(filterToken{pos: start}, "unterminated string")
+			}
+			i++
+			p.tokens = append(p.tokens, filterToken{kind: tokenString, text: s[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range filterOps {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return p.errorf(filterToken{pos: i}, "unexpected character %q", c)
+			}
+			p.tokens = append(p.tokens, filterToken{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	p.tokens = append(p.tokens, filterToken{kind: tokenEOF, text: "end of expression", pos: len(s)})
+	return nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// Пропускает оператор op, если он следующий
+func (p *filterParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return p.errorf(tok, "expected %q but got %q", op, tok.text)
+	}
+	return nil
+}
+
+// or := and ("||" and)*
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return filterNode{}, err
+		}
+
+		l, r := left.eval, right.eval
+		left = filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			return boolValue(l(u).truthy() || r(u).truthy())
+		}}
+	}
+
+	return left, nil
+}
+
+// and := unary ("&&" unary)*
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return filterNode{}, err
+		}
+
+		l, r := left.eval, right.eval
+		left = filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			return boolValue(l(u).truthy() && r(u).truthy())
+		}}
+	}
+
+	return left, nil
+}
+
+// unary := "!" unary | comparison
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return filterNode{}, err
+		}
+
+		eval := operand.eval
+		return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			return boolValue(!eval(u).truthy())
+		}}, nil
+	}
+
+	return p.parseComparison()
+}
+
+// comparison := primary (op primary | "=~" string | "in" "(" literal ("," literal)* ")")?
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	tok := p.peek()
+
+	if tok.kind == tokenIdent && tok.text == "in" {
+		p.next()
+		return p.parseIn(left)
+	}
+
+	if tok.kind != tokenOp {
+		return left, nil
+	}
+
+	switch tok.text {
+	case "=~":
+		p.next()
+		return p.parseMatch(left, tok)
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+	default:
+		return left, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	if left.kind != kindAny && right.kind != kindAny && left.kind != kindNull && right.kind != kindNull && left.kind != right.kind {
+		return filterNode{}, p.errorf(tok, "cannot compare %s with %s", left.kind, right.kind)
+	}
+
+	l, r := left.eval, right.eval
+
+	switch tok.text {
+	case "==":
+		return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			return boolValue(l(u).equal(r(u)))
+		}}, nil
+	case "!=":
+		return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+			return boolValue(!l(u).equal(r(u)))
+		}}, nil
+	}
+
+	for _, operand := range []filterNode{left, right} {
+		if operand.kind != kindAny && operand.kind != kindNumber {
+			return filterNode{}, p.errorf(tok, "operator %s requires numbers, got %s", tok.text, operand.kind)
+		}
+	}
+
+	var less func(a, b float64) bool
+	switch tok.text {
+	case "<":
+		less = func(a, b float64) bool { return a < b }
+	case "<=":
+		less = func(a, b float64) bool { return a <= b }
+	case ">":
+		less = func(a, b float64) bool { return a > b }
+	case ">=":
+		less = func(a, b float64) bool { return a >= b }
+	}
+
+	return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+		a, b := l(u), r(u)
+		return boolValue(a.kind == kindNumber && b.kind == kindNumber && less(a.num, b.num))
+	}}, nil
+}
+
+// Оператор in со списком значений
+func (p *filterParser) parseIn(left filterNode) (filterNode, error) {
+	if err := p.expect("("); err != nil {
+		return filterNode{}, err
+	}
+
+	values := []filterValue{}
+	for {
+		tok := p.peek()
+		value, err := p.parseLiteral()
+		if err != nil {
+			return filterNode{}, err
+		}
+
+		if left.kind != kindAny && value.kind != kindNull && left.kind != value.kind {
+			return filterNode{}, p.errorf(tok, "cannot compare %s with %s", left.kind, value.kind)
+		}
+
+		values = append(values, value)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if err := p.expect(")"); err != nil {
+		return filterNode{}, err
+	}
+
+	eval := left.eval
+	return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+		value := eval(u)
+		for _, candidate := range values {
+			if value.equal(candidate) {
+				return boolValue(true)
+			}
+		}
+		return boolValue(false)
+	}}, nil
+}
+
+// Оператор =~, регулярное выражение компилируется сразу
+func (p *filterParser) parseMatch(left filterNode, op filterToken) (filterNode, error) {
+	if left.kind != kindAny && left.kind != kindString {
+		return filterNode{}, p.errorf(op, "operator =~ requires a string, got %s", left.kind)
+	}
+
+	tok := p.peek()
+	if tok.kind != tokenString {
+		return filterNode{}, p.errorf(tok, "operator =~ requires a string pattern")
+	}
+
+	pattern, err := p.parseLiteral()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	re, err := regexp.Compile(pattern.str)
+	if err != nil {
+		return filterNode{}, p.errorf(tok, "invalid pattern: %s", err)
+	}
+
+	eval := left.eval
+	return filterNode{kind: kindBool, eval: func(u Update) filterValue {
+		value := eval(u)
+		return boolValue(value.kind == kindString && re.MatchString(value.str))
+	}}, nil
+}
+
+// primary := "(" or ")" | literal | field
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return filterNode{}, err
+		}
+		return node, p.expect(")")
+	}
+
+	tok := p.peek()
+	if tok.kind == tokenIdent {
+		switch tok.text {
+		case "true", "false", "null":
+		default:
+			p.next()
+			field, ok := compileField(tok.text)
+			if !ok {
+				return filterNode{}, p.errorf(tok, "unknown field %q", tok.text)
+			}
+			return field, nil
+		}
+	}
+
+	value, err := p.parseLiteral()
+	if err != nil {
+		return filterNode{}, err
+	}
+
+	return filterNode{kind: value.kind, eval: func(Update) filterValue {
+		return value
+	}}, nil
+}
+
+// literal := number | string | "true" | "false" | "null"
+func (p *filterParser) parseLiteral() (filterValue, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return filterValue{}, p.errorf(tok, "invalid number %q", tok.text)
+		}
+		return filterValue{kind: kindNumber, num: num}, nil
+	case tokenString:
+		str, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return filterValue{}, p.errorf(tok, "invalid string %s", tok.text)
+		}
+		return filterValue{kind: kindString, str: str}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return boolValue(true), nil
+		case "false":
+			return boolValue(false), nil
+		case "null":
+			return filterValue{kind: kindNull}, nil
+		}
+	}
+
+	return filterValue{}, p.errorf(tok, "expected value but got %q", tok.text)
+}
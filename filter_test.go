@@ -0,0 +1,116 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestFilter(t *testing.T) {
+	incomingChat := vklongpoll.Update(`[4, 10, 1, 2000000001, 1700000000, "hello"]`)
+	outgoingUser := vklongpoll.Update(`[4, 11, 3, 123, 1700000000, "/start"]`)
+	typing := vklongpoll.Update(`[63, 2000000001, [123], 1, 1700000000]`)
+	command := vklongpoll.Update(`{"type": "message_new", "group_id": 1, "object": {"message": {"text": "/start \"x\"", "peer_id": 123}}}`)
+	reply := vklongpoll.Update(`{"type": "message_reply", "group_id": 1, "object": {"text": "ok"}}`)
+
+	cases := []struct {
+		expr    string
+		matches []vklongpoll.Update
+	}{
+		{`code in (4,5) && !flags.outbox && peer.kind == "chat"`, []vklongpoll.Update{incomingChat}},
+		{`code == 4 && flags.outbox`, []vklongpoll.Update{outgoingUser}},
+		{`code != 63`, []vklongpoll.Update{incomingChat, outgoingUser, command, reply}},
+		{`peer.kind == "user" || type == "message_reply"`, []vklongpoll.Update{outgoingUser, typing, reply}},
+		{`text =~ "^/"`, []vklongpoll.Update{outgoingUser}},
+		{`type == "message_new" && object.message.text =~ "^/"`, []vklongpoll.Update{command}},
+		{`object.message.peer_id >= 100 && group_id == 1`, []vklongpoll.Update{command}},
+		{`!(code == 4) && object == null`, []vklongpoll.Update{typing}},
+		{`code < 10 && message_id > 10`, []vklongpoll.Update{outgoingUser}},
+	}
+
+	all := []vklongpoll.Update{incomingChat, outgoingUser, typing, command, reply}
+	for _, c := range cases {
+		filter, err := vklongpoll.CompileFilter(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+
+		got := filter.Apply(append([]vklongpoll.Update{}, all...))
+		if len(got) != len(c.matches) {
+			t.Errorf("%s: expected %d updates but got %d: %s", c.expr, len(c.matches), len(got), got)
+			continue
+		}
+
+		for i := range got {
+			if string(got[i]) != string(c.matches[i]) {
+				t.Errorf("%s: expected update %s but got %s", c.expr, c.matches[i], got[i])
+			}
+		}
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	cases := map[string]string{
+		`cod == 4`:            `unknown field "cod"`,
+		`flags.outgoing`:      `unknown field "flags.outgoing"`,
+		`code == "4"`:         "cannot compare number with string",
+		`code in (4, "5")`:    "cannot compare number with string",
+		`text > "a"`:          "requires numbers",
+		`code =~ "4"`:         "requires a string",
+		`text =~ "("`:         "invalid pattern",
+		`code == 4 &&`:        "expected value",
+		`(code == 4`:          `expected ")"`,
+		`code == 4 code`:      "unexpected",
+		`text == "unfinished`: "unterminated string",
+		`code # 4`:            "unexpected character",
+	}
+
+	for expr, want := range cases {
+		_, err := vklongpoll.CompileFilter(expr)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q but got %v", expr, want, err)
+		}
+	}
+}
+
+func TestFilterOption(t *testing.T) {
+	serverUpdater, closeServers := startLongPollServers(t, []byte(`{"ts": 2, "updates": [[4, 1, 2, 123], [4, 2, 1, 123], [8, -123, 1]]}`))
+	defer closeServers()
+
+	opt, err := vklongpoll.OptionsFromJSON([]byte(`{"filter": "code == 4 && !flags.outbox"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverUpdater(opt)
+
+	updates, err := vklongpoll.New().RecvOpt(context.Background(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updates) != 1 || string(updates[0]) != "[4, 2, 1, 123]" {
+		t.Errorf("expected only incoming message but got %s", updates)
+	}
+
+	if _, err := vklongpoll.OptionsFromJSON([]byte(`{"filter": "unknown == 1"}`)); err == nil {
+		t.Error("expected error for unknown filter field")
+	}
+
+	dump, err := vklongpoll.DumpOptions(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := vklongpoll.OptionsFromJSON(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dumped.Filter == nil || dumped.Filter.String() != opt.Filter.String() {
+		t.Errorf("filter is missing in dumped options: %s", dump)
+	}
+}
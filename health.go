@@ -0,0 +1,144 @@
+package vklongpoll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Состояние здоровья Long Poll соединения
+type Health struct {
+	LastSuccess         time.Time     // Время последнего успешного запроса
+	LastUpdate          time.Time     // Время последнего запроса, вернувшего события
+	SinceLastUpdate     time.Duration // Время с последнего запроса, вернувшего события (0, если событий еще не было)
+	ConsecutiveFailures int           // Количество ошибок подряд
+	LastError           error         // Последняя ошибка
+	Server              string        // Хост текущего Long Poll сервера
+	CredentialsValid    bool          // Получены ли данные сервера и не отклонил ли их сервер
+}
+
+// Возвращает состояние здоровья соединения
+// Безопасно вызывать во время выполнения Recv
+func (v *VkLongPoll) Health() Health {
+	v.stateMx.RLock()
+	defer v.stateMx.RUnlock()
+
+	health := v.health
+	if !health.LastUpdate.IsZero() {
+		health.SinceLastUpdate = time.Since(health.LastUpdate)
+	}
+
+	return health
+}
+
+// Учитывает результат запроса, вызывается под блокировкой stateMx
+// Сбоями считаются ошибки транспорта, HTTP и данных сервера. Обновление ключа или ts (failed=1, 2, 3)
+// завершается успешно и сбоем не считается
+func (v *VkLongPoll) recordHealth(res *Response, err error) {
+	now := time.Now()
+
+	v.health.CredentialsValid = v.credsValid
+	if v.serverUrl != nil {
+		v.health.Server = v.serverUrl.Host
+	}
+
+	// Отмена запроса вызывающим кодом и закрытие соединения - не сбой соединения
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrClosed) {
+		return
+	}
+
+	if err != nil {
+		v.health.ConsecutiveFailures++
+		v.health.LastError = err
+		return
+	}
+
+	v.health.ConsecutiveFailures = 0
+	v.health.LastSuccess = now
+	if len(res.Updates) != 0 {
+		v.health.LastUpdate = now
+	}
+}
+
+// Пороги для HealthHandler
+type HealthThresholds struct {
+	MaxConsecutiveFailures int           // Соединение считается мертвым после стольких ошибок подряд (0 - не проверять)
+	MaxSinceSuccess        time.Duration // Соединение не готово, если столько времени не было успешных запросов (0 - не проверять)
+}
+
+// Возвращает пороги по умолчанию: не готово без успешных запросов в течение 3×wait,
+// мертво после 10 ошибок подряд
+func DefaultHealthThresholds(wait time.Duration) HealthThresholds {
+	return HealthThresholds{
+		MaxConsecutiveFailures: 10,
+		MaxSinceSuccess:        3 * wait,
+	}
+}
+
+// Проверяет, живо ли соединение
+func (h Health) Alive(thresholds HealthThresholds) bool {
+	return thresholds.MaxConsecutiveFailures == 0 || h.ConsecutiveFailures < thresholds.MaxConsecutiveFailures
+}
+
+// Проверяет, готово ли соединение получать события
+func (h Health) Ready(thresholds HealthThresholds) bool {
+	if !h.CredentialsValid || h.LastSuccess.IsZero() {
+		return false
+	}
+	return thresholds.MaxSinceSuccess == 0 || time.Since(h.LastSuccess) <= thresholds.MaxSinceSuccess
+}
+
+// Ответ HealthHandler
+type healthResponse struct {
+	Alive               bool      `json:"alive"`
+	Ready               bool      `json:"ready"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	LastUpdate          time.Time `json:"last_update,omitempty"`
+	SinceLastUpdate     string    `json:"since_last_update,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	Server              string    `json:"server,omitempty"`
+	CredentialsValid    bool      `json:"credentials_valid"`
+}
+
+// Возвращает HTTP обработчик для проверок liveness и readiness (например, в Kubernetes)
+// Запросы на пути, оканчивающиеся на /live, проверяют только liveness, остальные - readiness.
+// Отвечает 200, если проверка пройдена, и 503, если нет. В теле ответа - состояние соединения в JSON
+func HealthHandler(lp *VkLongPoll, thresholds HealthThresholds) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := lp.Health()
+
+		res := healthResponse{
+			Alive:               health.Alive(thresholds),
+			Ready:               health.Ready(thresholds),
+			LastSuccess:         health.LastSuccess,
+			LastUpdate:          health.LastUpdate,
+			ConsecutiveFailures: health.ConsecutiveFailures,
+			Server:              health.Server,
+			CredentialsValid:    health.CredentialsValid,
+		}
+
+		if health.SinceLastUpdate != 0 {
+			res.SinceLastUpdate = health.SinceLastUpdate.String()
+		}
+
+		if health.LastError != nil {
+			res.LastError = health.LastError.Error()
+		}
+
+		ok := res.Ready
+		if strings.HasSuffix(r.URL.Path, "/live") {
+			ok = res.Alive
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,99 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestHealth(t *testing.T) {
+	serverUpdater, closeServers := startLongPollServers(t, []byte(`{"ts":"2","updates":[1]}`))
+	defer closeServers()
+
+	lp := vklongpoll.New()
+	thresholds := vklongpoll.HealthThresholds{MaxConsecutiveFailures: 2, MaxSinceSuccess: time.Minute}
+	handler := vklongpoll.HealthHandler(lp, thresholds)
+
+	probe := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Error(err)
+		}
+		return rec.Code, body
+	}
+
+	t.Run("not ready before first poll", func(t *testing.T) {
+		if code, _ := probe("/ready"); code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d but got %d", http.StatusServiceUnavailable, code)
+		}
+
+		if code, _ := probe("/live"); code != http.StatusOK {
+			t.Errorf("expected status %d but got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("ready after successful poll", func(t *testing.T) {
+		if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		health := lp.Health()
+		if !health.CredentialsValid || health.LastSuccess.IsZero() || health.LastUpdate.IsZero() || health.Server == "" {
+			t.Errorf("unexpected health: %+v", health)
+		}
+
+		if code, body := probe("/ready"); code != http.StatusOK || body["ready"] != true {
+			t.Errorf("expected ready but got status %d and body %v", code, body)
+		}
+	})
+
+	t.Run("refresh and cancellation are not failures", func(t *testing.T) {
+		refreshUpdater, closeRefreshServers := startLongPollServers(t, []byte(`{"failed":2}`))
+		defer closeRefreshServers()
+
+		if _, err := lp.Recv(context.Background(), refreshUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := lp.Recv(ctx, serverUpdater); err == nil {
+			t.Fatal("expected error but got nil")
+		}
+
+		if health := lp.Health(); health.ConsecutiveFailures != 0 || health.LastError != nil {
+			t.Errorf("unexpected health: %+v", health)
+		}
+	})
+
+	t.Run("dead after consecutive failures", func(t *testing.T) {
+		lp.HttpClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, context.DeadlineExceeded
+		})}
+
+		for i := 0; i < 2; i++ {
+			if _, err := lp.Recv(context.Background(), serverUpdater); err == nil {
+				t.Fatal("expected error but got nil")
+			}
+		}
+
+		code, body := probe("/live")
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d but got %d", http.StatusServiceUnavailable, code)
+		}
+
+		if lastError, _ := body["last_error"].(string); strings.Contains(lastError, "longpoll_server_key") {
+			t.Errorf("server key leaked into last error: %s", lastError)
+		}
+	})
+}
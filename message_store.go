@@ -0,0 +1,336 @@
+package vklongpoll
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Коды событий пользовательского Long Poll для MessageStore
+const (
+	EventPeerDelete       = 13 // Удаление всех сообщений диалога до local_id
+	EventPeerRestore      = 14 // Восстановление сообщений диалога до local_id
+	EventMessageReplace   = 18 // Замена сообщения (например, после восстановления)
+	EventMessageCacheDrop = 19 // Сброс кеша сообщения
+)
+
+// Флаги удаленного сообщения
+const (
+	messageFlagDeleted    = 128
+	messageFlagDeletedAll = 131072
+)
+
+// Количество сообщений на диалог в MessageStore по умолчанию
+var DefaultMessagesPerPeer = 100
+
+// Время хранения сообщений в MessageStore по умолчанию
+var DefaultMessageTTL = 24 * time.Hour
+
+// Сообщение, собранное из событий Long Poll
+type Message struct {
+	ID          int64
+	PeerID      int64
+	Flags       int64
+	Timestamp   int64
+	Text        string
+	Extra       []byte           // Доп. поля (элемент 6 события), JSON
+	Attachments []byte           // Вложения (элемент 7 события), JSON
+	History     []MessageVersion // Предыдущие версии сообщения, от старых к новым
+	UpdatedAt   time.Time        // Время последнего изменения в хранилище
+}
+
+// Предыдущая версия сообщения
+type MessageVersion struct {
+	Text        string
+	Timestamp   int64
+	Attachments []byte
+	ReplacedAt  time.Time // Время, когда версия была заменена
+}
+
+// Проверяет, удалено ли сообщение
+func (m Message) Deleted() bool {
+	return m.Flags&(messageFlagDeleted|messageFlagDeletedAll) != 0
+}
+
+// Проверяет, исходящее ли сообщение
+func (m Message) Outbox() bool {
+	return m.Flags&messageFlagOutbox != 0
+}
+
+// Параметры MessageStore
+type MessageStoreOptions struct {
+	PerPeer int           // Максимальное количество сообщений в диалоге (0 - DefaultMessagesPerPeer), старые вытесняются
+	TTL     time.Duration // Время хранения сообщения с последнего изменения (0 - DefaultMessageTTL)
+}
+
+// Хранилище последних сообщений, собранное только из событий 4, 5, 2, 3, 13, 14, 18, 19 пользовательского Long Poll
+// Позволяет найти сообщение, к которому относится событие флагов, без запроса к API.
+// Безопасно использовать из нескольких горутин
+type MessageStore struct {
+	perPeer   int
+	ttl       time.Duration
+	peers     map[int64]*peerMessages
+	peerByID  map[int64]int64 // Диалог сообщения (события 2, 3, 19 могут не содержать peer_id)
+	lastSweep time.Time
+	mx        sync.RWMutex
+}
+
+// Сообщения одного диалога
+type peerMessages struct {
+	ids      []int64 // Идентификаторы по возрастанию
+	messages map[int64]*Message
+}
+
+// Создает MessageStore
+func NewMessageStore(opts MessageStoreOptions) *MessageStore {
+	if opts.PerPeer == 0 {
+		opts.PerPeer = DefaultMessagesPerPeer
+	}
+
+	if opts.TTL == 0 {
+		opts.TTL = DefaultMessageTTL
+	}
+
+	return &MessageStore{
+		perPeer:   opts.PerPeer,
+		ttl:       opts.TTL,
+		peers:     map[int64]*peerMessages{},
+		peerByID:  map[int64]int64{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Учитывает события, остальные события пропускаются
+// Данные событий копируются, поэтому их можно передавать в режиме ZeroCopy
+func (s *MessageStore) Handle(updates []Update) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	for _, u := range updates {
+		s.handle(u, now)
+	}
+
+	if now.Sub(s.lastSweep) >= s.ttl/10 {
+		s.sweep(now)
+	}
+}
+
+// Учитывает одно событие, вызывается под блокировкой
+func (s *MessageStore) handle(u Update, now time.Time) {
+	code, ok := updateInt(u, 0)
+	if !ok {
+		return
+	}
+
+	switch code {
+	case EventMessageNew, EventMessageEdit, EventMessageReplace:
+		messageID, ok1 := updateInt(u, 1)
+		flags, ok2 := updateInt(u, 2)
+		peerID, ok3 := updateInt(u, 3)
+		if !ok1 || !ok2 || !ok3 {
+			return
+		}
+
+		message := s.message(peerID, messageID)
+		if message == nil {
+			message = s.insert(peerID, messageID)
+		} else if code != EventMessageNew {
+			message.History = append(message.History, MessageVersion{
+				Text:        message.Text,
+				Timestamp:   message.Timestamp,
+				Attachments: message.Attachments,
+				ReplacedAt:  now,
+			})
+		}
+
+		message.Flags = flags
+		message.Timestamp, _ = updateInt(u, 4)
+		message.Text = updateString(u, 5)
+		message.Extra = updateRaw(u, 6)
+		message.Attachments = updateRaw(u, 7)
+		message.UpdatedAt = now
+	case EventMessageFlagsSet, EventMessageFlagsReset:
+		messageID, ok1 := updateInt(u, 1)
+		mask, ok2 := updateInt(u, 2)
+		if !ok1 || !ok2 {
+			return
+		}
+
+		peerID, ok := updateInt(u, 3)
+		if !ok {
+			peerID, ok = s.peerByID[messageID]
+		}
+
+		message := s.message(peerID, messageID)
+		if !ok || message == nil {
+			return
+		}
+
+		if code == EventMessageFlagsSet {
+			message.Flags |= mask
+		} else {
+			message.Flags &^= mask
+		}
+		message.UpdatedAt = now
+	case EventPeerDelete, EventPeerRestore:
+		peerID, ok1 := updateInt(u, 1)
+		localID, ok2 := updateInt(u, 2)
+		peer := s.peers[peerID]
+		if !ok1 || !ok2 || peer == nil {
+			return
+		}
+
+		for _, id := range peer.ids {
+			if id > localID {
+				break
+			}
+
+			message := peer.messages[id]
+			if code == EventPeerDelete {
+				message.Flags |= messageFlagDeleted
+			} else {
+				message.Flags &^= messageFlagDeleted | messageFlagDeletedAll
+			}
+			message.UpdatedAt = now
+		}
+	case EventMessageCacheDrop:
+		messageID, ok := updateInt(u, 1)
+		if !ok {
+			return
+		}
+
+		if peerID, ok := s.peerByID[messageID]; ok {
+			s.remove(peerID, messageID)
+		}
+	}
+}
+
+// Возвращает сообщение из хранилища, вызывается под блокировкой
+func (s *MessageStore) message(peerID, messageID int64) *Message {
+	peer := s.peers[peerID]
+	if peer == nil {
+		return nil
+	}
+	return peer.messages[messageID]
+}
+
+// Добавляет сообщение и вытесняет самые старые при переполнении, вызывается под блокировкой
+func (s *MessageStore) insert(peerID, messageID int64) *Message {
+	peer := s.peers[peerID]
+	if peer == nil {
+		peer = &peerMessages{messages: map[int64]*Message{}}
+		s.peers[peerID] = peer
+	}
+
+	i := sort.Search(len(peer.ids), func(i int) bool {
+		return peer.ids[i] >= messageID
+	})
+	peer.ids = append(peer.ids, 0)
+	copy(peer.ids[i+1:], peer.ids[i:])
+	peer.ids[i] = messageID
+
+	message := &Message{ID: messageID, PeerID: peerID}
+	peer.messages[messageID] = message
+	s.peerByID[messageID] = peerID
+
+	for len(peer.ids) > s.perPeer {
+		s.remove(peerID, peer.ids[0])
+	}
+
+	return message
+}
+
+// Удаляет сообщение из хранилища, вызывается под блокировкой
+func (s *MessageStore) remove(peerID, messageID int64) {
+	peer := s.peers[peerID]
+	if peer == nil {
+		return
+	}
+
+	if _, ok := peer.messages[messageID]; !ok {
+		return
+	}
+
+	delete(peer.messages, messageID)
+	delete(s.peerByID, messageID)
+
+	i := sort.Search(len(peer.ids), func(i int) bool {
+		return peer.ids[i] >= messageID
+	})
+	peer.ids = append(peer.ids[:i], peer.ids[i+1:]...)
+
+	if len(peer.ids) == 0 {
+		delete(s.peers, peerID)
+	}
+}
+
+// Удаляет сообщения, которые не изменялись дольше TTL, вызывается под блокировкой
+func (s *MessageStore) sweep(now time.Time) {
+	s.lastSweep = now
+
+	for peerID, peer := range s.peers {
+		for _, id := range append([]int64{}, peer.ids...) {
+			if s.expired(peer.messages[id], now) {
+				s.remove(peerID, id)
+			}
+		}
+	}
+}
+
+func (s *MessageStore) expired(message *Message, now time.Time) bool {
+	return now.Sub(message.UpdatedAt) > s.ttl
+}
+
+// Возвращает копию сообщения
+func (s *MessageStore) get(message *Message, now time.Time) (Message, bool) {
+	if message == nil || s.expired(message, now) {
+		return Message{}, false
+	}
+
+	copied := *message
+	copied.History = append([]MessageVersion(nil), message.History...)
+	return copied, true
+}
+
+// Возвращает сообщение диалога
+func (s *MessageStore) Message(peerID, messageID int64) (Message, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.get(s.message(peerID, messageID), time.Now())
+}
+
+// Возвращает сообщение по идентификатору без указания диалога
+func (s *MessageStore) MessageByID(messageID int64) (Message, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	peerID, ok := s.peerByID[messageID]
+	if !ok {
+		return Message{}, false
+	}
+
+	return s.get(s.message(peerID, messageID), time.Now())
+}
+
+// Возвращает сообщения диалога по возрастанию идентификатора
+func (s *MessageStore) Messages(peerID int64) []Message {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	peer := s.peers[peerID]
+	if peer == nil {
+		return nil
+	}
+
+	now := time.Now()
+	messages := make([]Message, 0, len(peer.ids))
+	for _, id := range peer.ids {
+		if message, ok := s.get(peer.messages[id], now); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
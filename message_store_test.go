@@ -0,0 +1,100 @@
+package vklongpoll_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestMessageStore(t *testing.T) {
+	store := vklongpoll.NewMessageStore(vklongpoll.MessageStoreOptions{PerPeer: 2})
+
+	store.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[4, 10, 1, 123, 1700000000, "hello", {"title": ""}, {"attach1_type": "photo"}]`),
+		vklongpoll.Update(`[4, 11, 3, 123, 1700000001, "hi"]`),
+		vklongpoll.Update(`[5, 10, 1, 123, 1700000002, "hello, world", {}, {}]`),
+		vklongpoll.Update(`[2, 11, 8]`),
+		vklongpoll.Update(`[4, 20, 1, 2000000001, 1700000003, "chat", {"from": "456"}]`),
+	})
+
+	message, ok := store.Message(123, 10)
+	if !ok {
+		t.Fatal("expected message 10 to be stored")
+	}
+
+	if message.Text != "hello, world" || len(message.History) != 1 || message.History[0].Text != "hello" {
+		t.Errorf("unexpected edited message: %+v", message)
+	}
+
+	if string(message.History[0].Attachments) != `{"attach1_type": "photo"}` {
+		t.Errorf("unexpected attachments in history: %s", message.History[0].Attachments)
+	}
+
+	if message, _ := store.MessageByID(11); message.Flags != 11 || !message.Outbox() {
+		t.Errorf("expected flags to be set on message 11 but got %+v", message)
+	}
+
+	if message, _ := store.Message(2000000001, 20); string(message.Extra) != `{"from": "456"}` {
+		t.Errorf("unexpected extra fields: %s", message.Extra)
+	}
+
+	store.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[13, 123, 10]`),
+		vklongpoll.Update(`[3, 11, 8, 123]`),
+	})
+
+	if message, _ := store.Message(123, 10); !message.Deleted() {
+		t.Error("expected message 10 to be deleted")
+	}
+
+	if message, _ := store.Message(123, 11); message.Deleted() || message.Flags != 3 {
+		t.Errorf("expected message 11 to keep only outbox flags but got %+v", message)
+	}
+
+	store.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[14, 123, 11]`),
+		vklongpoll.Update(`[18, 11, 3, 123, 1700000001, "hi!"]`),
+		vklongpoll.Update(`[19, 20]`),
+	})
+
+	if message, _ := store.Message(123, 10); message.Deleted() {
+		t.Error("expected message 10 to be restored")
+	}
+
+	if message, _ := store.Message(123, 11); message.Text != "hi!" || len(message.History) != 1 {
+		t.Errorf("expected message 11 to be replaced but got %+v", message)
+	}
+
+	if _, ok := store.MessageByID(20); ok {
+		t.Error("expected message 20 to be dropped from cache")
+	}
+
+	// Вытеснение самого старого сообщения при переполнении диалога
+	store.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 12, 1, 123, 1700000004, "new"]`)})
+
+	messages := store.Messages(123)
+	if len(messages) != 2 || messages[0].ID != 11 || messages[1].ID != 12 {
+		t.Errorf("expected messages 11 and 12 but got %+v", messages)
+	}
+}
+
+func TestMessageStoreTTL(t *testing.T) {
+	store := vklongpoll.NewMessageStore(vklongpoll.MessageStoreOptions{TTL: 50 * time.Millisecond})
+	store.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 10, 1, 123, 1700000000, "hello"]`)})
+
+	if _, ok := store.Message(123, 10); !ok {
+		t.Fatal("expected message to be stored")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, ok := store.Message(123, 10); ok {
+		t.Error("expected message to expire")
+	}
+
+	store.Handle(nil)
+	if messages := store.Messages(123); len(messages) != 0 {
+		t.Errorf("expected expired messages to be removed but got %+v", messages)
+	}
+}
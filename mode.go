@@ -0,0 +1,142 @@
+package vklongpoll
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Режим работы (набор флагов)
+type Mode int
+
+// Получать вложения
+const Attachments Mode = 2
+
+// Возвращать расширенный набор событий
+const Extended Mode = 8
+
+// Возвращать поле pts для дальнейшей работы
+const ReturnPts Mode = 32
+
+// Возвращать поля $extra
+const ExtraFields Mode = 64
+
+// Возвращать поле random_id
+const ReturnRandomId Mode = 128
+
+// Имена режимов для String и ParseMode
+var modeNames = []struct {
+	mode Mode
+	name string
+}{
+	{Attachments, "attachments"},
+	{Extended, "extended"},
+	{ReturnPts, "pts"},
+	{ExtraFields, "extra"},
+	{ReturnRandomId, "random_id"},
+}
+
+// Все известные режимы
+const allModes = Attachments | Extended | ReturnPts | ExtraFields | ReturnRandomId
+
+// Объединяет режимы работы
+// Повторяющиеся режимы учитываются один раз: SumModes(Attachments, Attachments) == Attachments
+func SumModes(modes ...Mode) Mode {
+	var s Mode = 0
+	for _, mode := range modes {
+		s = s | mode
+	}
+	return s
+}
+
+// Проверяет, включены ли все указанные режимы
+func (m Mode) Has(mode Mode) bool {
+	return m&mode == mode
+}
+
+// Возвращает режим без указанных режимов
+func (m Mode) Without(modes ...Mode) Mode {
+	return m &^ SumModes(modes...)
+}
+
+// Проверяет, что в режиме нет неизвестных флагов
+func (m Mode) Validate() error {
+	if m < 0 || m&^allModes != 0 {
+		return fmt.Errorf("invalid mode %d: unknown flags %d", int(m), int(m&^allModes))
+	}
+	return nil
+}
+
+// Возвращает режим в виде "attachments|extended|pts"
+// Неизвестные флаги выводятся числом
+func (m Mode) String() string {
+	if m == 0 {
+		return "0"
+	}
+
+	names := []string{}
+	for _, mode := range modeNames {
+		if m.Has(mode.mode) {
+			names = append(names, mode.name)
+		}
+	}
+
+	if unknown := m &^ allModes; unknown != 0 {
+		names = append(names, strconv.Itoa(int(unknown)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Разбирает режим из строки вида "attachments,pts" или "attachments|pts"
+// Вместо имен можно указывать числа, пустая строка - режим 0
+func ParseMode(s string) (Mode, error) {
+	var m Mode = 0
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '|'
+	}) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mode, err := parseModeName(part)
+		if err != nil {
+			return 0, err
+		}
+		m = m | mode
+	}
+
+	return m, m.Validate()
+}
+
+// Возвращает режим по имени или числу
+func parseModeName(name string) (Mode, error) {
+	for _, mode := range modeNames {
+		if strings.EqualFold(mode.name, name) {
+			return mode.mode, nil
+		}
+	}
+
+	num, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown mode: %q", name)
+	}
+
+	return Mode(num), nil
+}
+
+// Реализует encoding.TextMarshaler
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Реализует encoding.TextUnmarshaler, позволяет использовать Mode в JSON и flag.TextVar
+func (m *Mode) UnmarshalText(text []byte) error {
+	mode, err := ParseMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
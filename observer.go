@@ -0,0 +1,70 @@
+package vklongpoll
+
+import "context"
+
+// Наблюдатель за работой Long Poll соединения
+// Все обработчики необязательные, можно задать только нужные.
+// Используется для трассировки, метрик и логирования (см. пакет otelvklongpoll)
+type Observer struct {
+	// Вызывается перед запросом a_check, возвращенный контекст используется для запроса
+	PollStart func(ctx context.Context, info PollInfo) context.Context
+	// Вызывается после запроса a_check (в том числе при ошибке)
+	PollDone func(ctx context.Context, info PollInfo)
+	// Вызывается перед вызовом ServerUpdater, возвращенный контекст передается в ServerUpdater
+	ServerUpdateStart func(ctx context.Context) context.Context
+	// Вызывается после вызова ServerUpdater
+	ServerUpdateDone func(ctx context.Context, creds *ServerCredentials, err error)
+	// Вызывается после согласования версии Long Poll (см. WithVersionNegotiation)
+	VersionNegotiated func(ctx context.Context, requested int, negotiated int)
+}
+
+// Информация о запросе a_check
+// Ключ сервера сюда намеренно не попадает
+type PollInfo struct {
+	Ts      int64  // Значение ts, с которым был сделан запрос (после завершения - новое значение)
+	Host    string // Хост Long Poll сервера
+	Failed  int64  // Значение поля failed из ответа
+	Updates int    // Количество полученных событий
+	Proxy   string // Имя прокси из ProxyPool, через который был отправлен запрос
+	Err     error  // Ошибка запроса, если была
+}
+
+// Вызывает PollStart, если он задан
+func (o *Observer) pollStart(ctx context.Context, info PollInfo) context.Context {
+	if o == nil || o.PollStart == nil {
+		return ctx
+	}
+	return o.PollStart(ctx, info)
+}
+
+// Вызывает PollDone, если он задан
+func (o *Observer) pollDone(ctx context.Context, info PollInfo) {
+	if o == nil || o.PollDone == nil {
+		return
+	}
+	o.PollDone(ctx, info)
+}
+
+// Вызывает ServerUpdateStart, если он задан
+func (o *Observer) serverUpdateStart(ctx context.Context) context.Context {
+	if o == nil || o.ServerUpdateStart == nil {
+		return ctx
+	}
+	return o.ServerUpdateStart(ctx)
+}
+
+// Вызывает ServerUpdateDone, если он задан
+func (o *Observer) serverUpdateDone(ctx context.Context, creds *ServerCredentials, err error) {
+	if o == nil || o.ServerUpdateDone == nil {
+		return
+	}
+	o.ServerUpdateDone(ctx, creds, err)
+}
+
+// Вызывает VersionNegotiated, если он задан
+func (o *Observer) versionNegotiated(ctx context.Context, requested int, negotiated int) {
+	if o == nil || o.VersionNegotiated == nil {
+		return
+	}
+	o.VersionNegotiated(ctx, requested, negotiated)
+}
@@ -6,24 +6,6 @@ import (
 	"time"
 )
 
-// Режим работы
-type Mode int
-
-// Получать вложения
-const Attachments Mode = 2
-
-// Возвращать расширенный набор событий
-const Extended Mode = 8
-
-// Возвращать поле pts для дальнейшей работы
-const ReturnPts Mode = 32
-
-// Возвращать поля $extra
-const ExtraFields Mode = 64
-
-// Возвращать поле random_id
-const ReturnRandomId Mode = 128
-
 // Длительность запроса по умолчанию
 var DefaultWait = 90 * time.Second
 
@@ -33,6 +15,9 @@ var DefaultMode Mode = 0
 // Версия Long Poll по умолчанию
 var DefaultVersion = 3
 
+// Максимальный размер ответа по умолчанию (0 - без ограничений)
+var DefaultMaxResponseBytes int64 = 0
+
 // Путь параметра, где в ответе сервера хранятся обновления
 var DefaultUpdatesJsonPath = []string{"updates"}
 
@@ -40,30 +25,55 @@ type ServerUpdater func(ctx context.Context) (*ServerCredentials, error)
 
 type ParamsMerger func(u url.Values)
 type VkLongPollOptions struct {
-	Wait            time.Duration
-	ServerUpdater   ServerUpdater
-	Mode            Mode
-	Version         int
-	ParamsMerger    ParamsMerger
-	UpdatesJsonPath []string
+	Wait             time.Duration
+	ServerUpdater    ServerUpdater
+	Mode             Mode
+	Version          int
+	ParamsMerger     ParamsMerger
+	UpdatesJsonPath  []string
+	Observer         *Observer
+	MaxResponseBytes int64         // Максимальный размер ответа Long Poll сервера в байтах, 0 - без ограничений
+	ZeroCopy         bool          // События ссылаются на буфер ответа и действительны до Response.Release или следующего вызова Recv
+	NegotiateVersion bool          // Согласовывать версию Long Poll при failed=4
+	Params           url.Values    // Дополнительные параметры запроса (применяются до ParamsMerger)
+	Filter           *Filter       // Фильтр событий, события, не прошедшие фильтр, отбрасываются
+	Sinks            []UpdatesSink // Получатели событий, вызываются до продвижения ts (см. WithSink)
 }
 
 type ServerCredentials struct {
 	Ts        int64    // Новое значение TS
 	ServerURL *url.URL // Новый URL сервера
 	Key       string   // Новый ключ
+	Pts       *Pts     // Значение pts, если сервер его вернул
+	Source    string   // Имя обработчика, который получил данные (заполняет FallbackServerUpdater)
+	Cached    bool     // Данные взяты из кеша (заполняет SharedServerUpdater), ts в них может быть устаревшим
 }
 
 type VkLongPollOption func(v *VkLongPollOptions)
 
+type optionsContextKey struct{}
+
+// Возвращает опции соединения, с которыми был вызван ServerUpdater
+// Позволяет ServerUpdater согласовать параметры запроса с опциями (например, lp_version с Version)
+func OptionsFromContext(ctx context.Context) *VkLongPollOptions {
+	opt, _ := ctx.Value(optionsContextKey{}).(*VkLongPollOptions)
+	return opt
+}
+
+// Сохраняет опции соединения в контекст
+func withOptions(ctx context.Context, opt *VkLongPollOptions) context.Context {
+	return context.WithValue(ctx, optionsContextKey{}, opt)
+}
+
 // Создает опции по умолчанию
 func NewOptions() *VkLongPollOptions {
 	return &VkLongPollOptions{
-		Wait:            DefaultWait,
-		ServerUpdater:   nil,
-		Mode:            DefaultMode,
-		Version:         DefaultVersion,
-		UpdatesJsonPath: DefaultUpdatesJsonPath,
+		Wait:             DefaultWait,
+		ServerUpdater:    nil,
+		Mode:             DefaultMode,
+		Version:          DefaultVersion,
+		UpdatesJsonPath:  DefaultUpdatesJsonPath,
+		MaxResponseBytes: DefaultMaxResponseBytes,
 	}
 }
 
@@ -93,16 +103,7 @@ func WithWait(wait time.Duration) VkLongPollOption {
 	}
 }
 
-// Суммирует режимы работы
-func SumModes(modes ...Mode) Mode {
-	var s Mode = 0
-	for _, mode := range modes {
-		s = s + mode
-	}
-	return s
-}
-
-// Находит сумму всех указанных режимов и устанавливает в настройки подключения
+// Объединяет все указанные режимы и устанавливает в настройки подключения
 func WithModeSum(modes ...Mode) VkLongPollOption {
 	return func(v *VkLongPollOptions) {
 		WithMode(SumModes(modes...))(v)
@@ -117,6 +118,22 @@ func WithParamsMerger(merger ParamsMerger) VkLongPollOption {
 	}
 }
 
+// Устанавливает дополнительные параметры запроса к Long Poll серверу
+// В отличие от WithParamsMerger, параметры можно загрузить из конфигурации (см. OptionsFromJSON)
+func WithParams(params url.Values) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.Params = params
+	}
+}
+
+// Устанавливает фильтр событий (см. CompileFilter)
+// Recv возвращает только события, прошедшие фильтр
+func WithFilter(filter *Filter) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.Filter = filter
+	}
+}
+
 // Устанавливает режим работы в натсройки подключения
 func WithMode(mode Mode) VkLongPollOption {
 	return func(v *VkLongPollOptions) {
@@ -137,3 +154,37 @@ func WithVersion(version int) VkLongPollOption {
 		v.Version = version
 	}
 }
+
+// Устанавливает наблюдателя за запросами к Long Poll серверу и вызовами ServerUpdater
+func WithObserver(observer *Observer) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.Observer = observer
+	}
+}
+
+// Ограничивает размер ответа Long Poll сервера.
+// При превышении Recv вернет ошибку ErrResponseTooLarge
+func WithMaxResponseBytes(max int64) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.MaxResponseBytes = max
+	}
+}
+
+// Включает режим без копирования событий:
+// события ссылаются на буфер ответа, который возвращается в пул после обработки (Response.Release,
+// см. RecvResponse) или при следующем вызове Recv.
+// Используйте, только если события обрабатываются до освобождения буфера
+func WithZeroCopy(zeroCopy bool) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.ZeroCopy = zeroCopy
+	}
+}
+
+// Включает согласование версии Long Poll:
+// если сервер ответил failed=4, то выбирается наибольшая поддерживаемая версия не выше Version,
+// данные сервера обновляются с этой версией, и запрос повторяется
+func WithVersionNegotiation(negotiate bool) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.NegotiateVersion = negotiate
+	}
+}
@@ -52,19 +52,21 @@ func TestNewOptions(t *testing.T) {
 		verionOpt := 1
 		modeOpt := -1
 
+		defaultWait, defaultVersion, defaultMode := vklongpoll.DefaultWait, vklongpoll.DefaultVersion, vklongpoll.DefaultMode
+		defer func() {
+			vklongpoll.DefaultWait = defaultWait
+			vklongpoll.DefaultVersion = defaultVersion
+			vklongpoll.DefaultMode = defaultMode
+		}()
+
 		vklongpoll.DefaultWait = waitOpt
 		vklongpoll.DefaultVersion = verionOpt
 		vklongpoll.DefaultMode = vklongpoll.Mode(modeOpt)
 
+		// Сравниваются только измененные значения, остальные поля проверяет TestBuildOptions
 		opt := vklongpoll.NewOptions()
-		expectedOpt := &vklongpoll.VkLongPollOptions{
-			Wait:    waitOpt,
-			Mode:    vklongpoll.Mode(modeOpt),
-			Version: verionOpt,
-		}
-
-		if !reflect.DeepEqual(expectedOpt, opt) {
-			t.Errorf("expected options %v but got %v", expectedOpt, opt)
+		if opt.Wait != waitOpt || opt.Mode != vklongpoll.Mode(modeOpt) || opt.Version != verionOpt {
+			t.Errorf("expected wait %s, mode %d, version %d but got %v", waitOpt, modeOpt, verionOpt, opt)
 		}
 	})
 }
@@ -88,4 +90,59 @@ func TestModeSum(t *testing.T) {
 			t.Errorf("expected sum %d but got %d", expected, sum)
 		}
 	})
+	t.Run("repeated modes", func(t *testing.T) {
+		sum := vklongpoll.SumModes(vklongpoll.Attachments, vklongpoll.Attachments)
+		if sum != vklongpoll.Attachments {
+			t.Errorf("expected %d but got %d", vklongpoll.Attachments, sum)
+		}
+	})
+}
+
+func TestMode(t *testing.T) {
+	mode := vklongpoll.SumModes(vklongpoll.Attachments, vklongpoll.Extended, vklongpoll.ReturnPts)
+
+	t.Run("has and without", func(t *testing.T) {
+		if !mode.Has(vklongpoll.Attachments|vklongpoll.ReturnPts) || mode.Has(vklongpoll.ExtraFields) {
+			t.Errorf("unexpected flags in %d", mode)
+		}
+
+		without := mode.Without(vklongpoll.Extended)
+		if without != vklongpoll.Attachments|vklongpoll.ReturnPts {
+			t.Errorf("expected %d but got %d", vklongpoll.Attachments|vklongpoll.ReturnPts, without)
+		}
+	})
+
+	t.Run("string", func(t *testing.T) {
+		expected := "attachments|extended|pts"
+		if mode.String() != expected {
+			t.Errorf("expected %q but got %q", expected, mode.String())
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		cases := map[string]vklongpoll.Mode{
+			"":                         0,
+			"attachments,pts":          vklongpoll.Attachments | vklongpoll.ReturnPts,
+			"attachments|extended|pts": mode,
+			"2, extra":                 vklongpoll.Attachments | vklongpoll.ExtraFields,
+		}
+
+		for s, expected := range cases {
+			parsed, err := vklongpoll.ParseMode(s)
+			if err != nil {
+				t.Errorf("parse %q error: %s", s, err)
+			}
+			if parsed != expected {
+				t.Errorf("expected mode %d for %q but got %d", expected, s, parsed)
+			}
+		}
+	})
+
+	t.Run("reject unknown", func(t *testing.T) {
+		for _, s := range []string{"typing", "4", "-1"} {
+			if _, err := vklongpoll.ParseMode(s); err == nil {
+				t.Errorf("expected error for %q", s)
+			}
+		}
+	})
 }
@@ -0,0 +1,20 @@
+module github.com/ciricc/vklongpoll/otelvklongpoll
+
+go 1.18
+
+require (
+	github.com/buger/jsonparser v1.1.1
+	github.com/ciricc/vklongpoll v0.0.0
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require (
+	github.com/ciricc/vkapiexecutor v0.2.0-alpha // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+)
+
+replace github.com/ciricc/vklongpoll => ../
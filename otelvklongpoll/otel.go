@@ -0,0 +1,150 @@
+// Интеграция vklongpoll с OpenTelemetry
+// Создает span на каждый запрос a_check и вызов ServerUpdater,
+// а также дочерние span'ы на обработку каждого события
+package otelvklongpoll
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+	"github.com/ciricc/vklongpoll"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Имя библиотеки инструментирования
+const ScopeName = "github.com/ciricc/vklongpoll/otelvklongpoll"
+
+// Ключи атрибутов span'ов
+const (
+	TsKey         = attribute.Key("vklongpoll.ts")
+	UpdatesKey    = attribute.Key("vklongpoll.updates")
+	FailedKey     = attribute.Key("vklongpoll.failed")
+	UpdateTypeKey = attribute.Key("vklongpoll.update.type")
+	ServerHostKey = attribute.Key("server.address")
+)
+
+// Создает span'ы для Long Poll соединения
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+type Option func(t *tracerOptions)
+
+type tracerOptions struct {
+	provider trace.TracerProvider
+}
+
+// Устанавливает провайдер трассировки, по умолчанию используется глобальный otel.GetTracerProvider()
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *tracerOptions) {
+		t.provider = provider
+	}
+}
+
+// Создает трассировщик
+func New(opts ...Option) *Tracer {
+	opt := tracerOptions{}
+	for _, option := range opts {
+		option(&opt)
+	}
+
+	if opt.provider == nil {
+		opt.provider = otel.GetTracerProvider()
+	}
+
+	return &Tracer{
+		tracer: opt.provider.Tracer(ScopeName),
+	}
+}
+
+// Возвращает опцию Long Poll соединения, которая включает трассировку
+func WithTracing(opts ...Option) vklongpoll.VkLongPollOption {
+	return vklongpoll.WithObserver(New(opts...).Observer())
+}
+
+// Возвращает наблюдателя для vklongpoll.WithObserver
+func (t *Tracer) Observer() *vklongpoll.Observer {
+	return &vklongpoll.Observer{
+		PollStart: func(ctx context.Context, info vklongpoll.PollInfo) context.Context {
+			ctx, _ = t.tracer.Start(ctx, "vklongpoll.a_check",
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					ServerHostKey.String(info.Host),
+					TsKey.Int64(info.Ts),
+				),
+			)
+			return ctx
+		},
+		PollDone: func(ctx context.Context, info vklongpoll.PollInfo) {
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(
+				TsKey.Int64(info.Ts),
+				UpdatesKey.Int(info.Updates),
+				FailedKey.Int64(info.Failed),
+			)
+			endSpan(span, info.Err)
+		},
+		ServerUpdateStart: func(ctx context.Context) context.Context {
+			ctx, _ = t.tracer.Start(ctx, "vklongpoll.server_update")
+			return ctx
+		},
+		ServerUpdateDone: func(ctx context.Context, creds *vklongpoll.ServerCredentials, err error) {
+			span := trace.SpanFromContext(ctx)
+			if creds != nil {
+				span.SetAttributes(TsKey.Int64(creds.Ts))
+				if creds.ServerURL != nil {
+					span.SetAttributes(ServerHostKey.String(creds.ServerURL.Host))
+				}
+			}
+			endSpan(span, err)
+		},
+	}
+}
+
+// Создает span обработки одного события
+// Передайте возвращенный контекст в обработчик, чтобы запросы к VK API попали в тот же trace
+func (t *Tracer) StartUpdate(ctx context.Context, update vklongpoll.Update) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "vklongpoll.update",
+		trace.WithAttributes(UpdateTypeKey.String(updateType(update))),
+	)
+}
+
+// Вызывает обработчик для каждого события внутри отдельного span'а
+// Останавливается на первой ошибке обработчика
+func (t *Tracer) Handle(ctx context.Context, updates []vklongpoll.Update, handler func(ctx context.Context, update vklongpoll.Update) error) error {
+	for _, update := range updates {
+		updateCtx, span := t.StartUpdate(ctx, update)
+		err := handler(updateCtx, update)
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Завершает span, записывая ошибку, если она есть
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Возвращает тип события: поле type для Bots Long Poll или код события для пользовательского Long Poll
+func updateType(update vklongpoll.Update) string {
+	if eventType, err := jsonparser.GetString(update, "type"); err == nil {
+		return eventType
+	}
+
+	if code, err := jsonparser.GetInt(update, "[0]"); err == nil {
+		return strconv.FormatInt(code, 10)
+	}
+
+	return "unknown"
+}
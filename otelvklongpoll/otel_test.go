@@ -0,0 +1,83 @@
+package otelvklongpoll_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+	"github.com/ciricc/vklongpoll/otelvklongpoll"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := otelvklongpoll.New(otelvklongpoll.WithTracerProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	))
+
+	observer := tracer.Observer()
+	ctx := context.Background()
+
+	t.Run("poll span", func(t *testing.T) {
+		pollCtx := observer.PollStart(ctx, vklongpoll.PollInfo{Ts: 1, Host: "lp.vk.com"})
+		observer.PollDone(pollCtx, vklongpoll.PollInfo{Ts: 2, Host: "lp.vk.com", Updates: 3})
+
+		spans := recorder.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("expected 1 ended span but got %d", len(spans))
+		}
+
+		attrs := map[string]interface{}{}
+		for _, attr := range spans[0].Attributes() {
+			attrs[string(attr.Key)] = attr.Value.AsInterface()
+		}
+
+		if attrs["vklongpoll.ts"] != int64(2) || attrs["vklongpoll.updates"] != int64(3) || attrs["server.address"] != "lp.vk.com" {
+			t.Errorf("unexpected poll span attributes: %v", attrs)
+		}
+	})
+
+	t.Run("server update span without key", func(t *testing.T) {
+		serverUrl, _ := url.Parse("https://lp.vk.com/wh1")
+		updateCtx := observer.ServerUpdateStart(ctx)
+		observer.ServerUpdateDone(updateCtx, &vklongpoll.ServerCredentials{Ts: 5, ServerURL: serverUrl, Key: "secret"}, nil)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		for _, attr := range span.Attributes() {
+			if attr.Value.Emit() == "secret" {
+				t.Errorf("server key leaked into attribute %q", attr.Key)
+			}
+		}
+	})
+
+	t.Run("update spans are children", func(t *testing.T) {
+		parentCtx, parent := tracer.StartUpdate(ctx, vklongpoll.Update(`{"type":"message_new"}`))
+		defer parent.End()
+
+		handlerErr := errors.New("handler error")
+		err := tracer.Handle(parentCtx, []vklongpoll.Update{
+			vklongpoll.Update(`[4,1,0]`),
+			vklongpoll.Update(`{"type":"message_new"}`),
+		}, func(ctx context.Context, update vklongpoll.Update) error {
+			return handlerErr
+		})
+
+		if err != handlerErr {
+			t.Errorf("expected handler error but got %v", err)
+		}
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("update span is not a child of the handler context span")
+		}
+
+		if span.Attributes()[0].Value.AsString() != "4" {
+			t.Errorf("expected update type 4 but got %v", span.Attributes()[0].Value.AsString())
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package vklongpoll
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+// Коды событий присутствия пользовательского Long Poll
+const (
+	EventFriendOnline   = 8  // Друг появился в сети
+	EventFriendOffline  = 9  // Друг вышел из сети
+	EventUserTyping     = 61 // Пользователь набирает текст в личном диалоге
+	EventChatTyping     = 62 // Пользователь набирает текст в беседе
+	EventTyping         = 63 // Пользователи набирают текст в диалоге
+	EventRecordingAudio = 64 // Пользователи записывают голосовое сообщение
+)
+
+// Время, в течение которого VK считает пользователя набирающим текст после последнего события
+var DefaultTypingTTL = 10 * time.Second
+
+// Платформа, с которой пользователь в сети
+type Platform int
+
+const (
+	PlatformUnknown Platform = iota
+	PlatformMobile
+	PlatformIPhone
+	PlatformIPad
+	PlatformAndroid
+	PlatformWindowsPhone
+	PlatformWindows
+	PlatformWeb
+)
+
+var platformNames = []string{"unknown", "mobile", "iphone", "ipad", "android", "wphone", "windows", "web"}
+
+func (p Platform) String() string {
+	if p < 0 || int(p) >= len(platformNames) {
+		return platformNames[PlatformUnknown]
+	}
+	return platformNames[p]
+}
+
+// Действие пользователя в диалоге
+type Activity string
+
+const (
+	ActivityTyping       Activity = "typing"
+	ActivityAudioMessage Activity = "audiomessage"
+)
+
+// Присутствие пользователя в сети
+type Presence struct {
+	UserID   int64
+	Online   bool
+	Platform Platform
+	Since    time.Time // Время последнего изменения
+}
+
+// Тип изменения присутствия
+type PresenceChangeKind int
+
+const (
+	PresenceOnline        PresenceChangeKind = iota // Пользователь появился в сети
+	PresenceOffline                                 // Пользователь вышел из сети
+	PresenceActivityStart                           // Пользователь начал действие в диалоге (набор текста, запись голосового)
+	PresenceActivityStop                            // Действие закончилось (истекло или пользователь отправил сообщение)
+)
+
+// Изменение присутствия
+type PresenceChange struct {
+	Kind     PresenceChangeKind
+	UserID   int64
+	PeerID   int64    // Диалог (для действий)
+	Activity Activity // Действие (для PresenceActivityStart и PresenceActivityStop)
+	Platform Platform // Платформа (для PresenceOnline)
+}
+
+// Присутствие и действия пользователей, собранные из событий 8, 9, 61-64 пользовательского Long Poll
+// и события message_typing_state Bots Long Poll
+// Действия истекают через TypingTTL после последнего события. Безопасно использовать из нескольких горутин
+type PresenceTracker struct {
+	typingTTL   time.Duration
+	presence    map[int64]Presence
+	activities  map[activityKey]*activity
+	subscribers subscribers[PresenceChange]
+	mx          sync.RWMutex
+}
+
+type activityKey struct {
+	userID int64
+	peerID int64
+}
+
+type activity struct {
+	activity Activity
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// Создает PresenceTracker, typingTTL - время жизни действия (0 - DefaultTypingTTL)
+func NewPresenceTracker(typingTTL time.Duration) *PresenceTracker {
+	if typingTTL == 0 {
+		typingTTL = DefaultTypingTTL
+	}
+
+	return &PresenceTracker{
+		typingTTL:  typingTTL,
+		presence:   map[int64]Presence{},
+		activities: map[activityKey]*activity{},
+	}
+}
+
+// Учитывает события, остальные события пропускаются
+func (t *PresenceTracker) Handle(updates []Update) {
+	changes := []PresenceChange{}
+
+	t.mx.Lock()
+	for _, u := range updates {
+		changes = t.handle(u, changes)
+	}
+	t.mx.Unlock()
+
+	t.subscribers.notify(changes...)
+}
+
+// Учитывает одно событие, вызывается под блокировкой
+func (t *PresenceTracker) handle(u Update, changes []PresenceChange) []PresenceChange {
+	if eventType, err := jsonparser.GetString(u, "type"); err == nil {
+		if eventType != "message_typing_state" {
+			return changes
+		}
+
+		state, _ := jsonparser.GetString(u, "object", "state")
+		fromID, err := jsonparser.GetInt(u, "object", "from_id")
+		if err != nil {
+			return changes
+		}
+
+		// to_id - это сообщество, а диалог с пользователем имеет peer_id пользователя
+		return t.startActivity(fromID, fromID, Activity(state), changes)
+	}
+
+	code, ok := updateInt(u, 0)
+	if !ok {
+		return changes
+	}
+
+	switch code {
+	case EventFriendOnline, EventFriendOffline:
+		userID, ok1 := updateInt(u, 1)
+		extra, ok2 := updateInt(u, 2)
+		if !ok1 || !ok2 {
+			return changes
+		}
+
+		// Идентификатор пользователя передается со знаком минус
+		if userID < 0 {
+			userID = -userID
+		}
+
+		presence := Presence{UserID: userID, Online: code == EventFriendOnline, Since: time.Now()}
+		change := PresenceChange{Kind: PresenceOffline, UserID: userID}
+		if presence.Online {
+			presence.Platform = Platform(extra & 0xFF)
+			change.Kind = PresenceOnline
+			change.Platform = presence.Platform
+		}
+
+		t.presence[userID] = presence
+		return append(changes, change)
+	case EventUserTyping:
+		userID, ok := updateInt(u, 1)
+		if !ok {
+			return changes
+		}
+		return t.startActivity(userID, userID, ActivityTyping, changes)
+	case EventChatTyping:
+		userID, ok1 := updateInt(u, 1)
+		chatID, ok2 := updateInt(u, 2)
+		if !ok1 || !ok2 {
+			return changes
+		}
+		return t.startActivity(userID, chatPeerOffset+chatID, ActivityTyping, changes)
+	case EventTyping, EventRecordingAudio:
+		peerID, ok := updateInt(u, 1)
+		if !ok {
+			return changes
+		}
+
+		act := ActivityTyping
+		if code == EventRecordingAudio {
+			act = ActivityAudioMessage
+		}
+
+		jsonparser.ArrayEach(u, func(value []byte, dataType jsonparser.ValueType, _ int, _ error) {
+			if userID, err := jsonparser.ParseInt(value); err == nil && dataType == jsonparser.Number {
+				changes = t.startActivity(userID, peerID, act, changes)
+			}
+		}, "[2]")
+		return changes
+	case EventMessageNew:
+		flags, ok1 := updateInt(u, 2)
+		peerID, ok2 := updateInt(u, 3)
+		if !ok1 || !ok2 || flags&messageFlagOutbox != 0 {
+			return changes
+		}
+
+		// В беседах автор сообщения передается в доп. полях
+		fromID := peerID
+		if from, err := jsonparser.GetString(u, "[6]", "from"); err == nil {
+			if id, err := jsonparser.ParseInt([]byte(from)); err == nil {
+				fromID = id
+			}
+		}
+
+		// Отправленное сообщение завершает набор текста
+		return t.stopActivity(activityKey{userID: fromID, peerID: peerID}, changes)
+	}
+
+	return changes
+}
+
+// Начинает или продлевает действие, вызывается под блокировкой
+func (t *PresenceTracker) startActivity(userID, peerID int64, act Activity, changes []PresenceChange) []PresenceChange {
+	key := activityKey{userID: userID, peerID: peerID}
+
+	if current, ok := t.activities[key]; ok {
+		current.deadline = time.Now().Add(t.typingTTL)
+		current.timer.Reset(t.typingTTL)
+		if current.activity == act {
+			return changes
+		}
+
+		changes = append(changes, PresenceChange{Kind: PresenceActivityStop, UserID: userID, PeerID: peerID, Activity: current.activity})
+		current.activity = act
+		return append(changes, PresenceChange{Kind: PresenceActivityStart, UserID: userID, PeerID: peerID, Activity: act})
+	}
+
+	current := &activity{activity: act, deadline: time.Now().Add(t.typingTTL)}
+	current.timer = time.AfterFunc(t.typingTTL, func() {
+		t.expire(key, current)
+	})
+	t.activities[key] = current
+
+	return append(changes, PresenceChange{Kind: PresenceActivityStart, UserID: userID, PeerID: peerID, Activity: act})
+}
+
+// Завершает действие, вызывается под блокировкой
+func (t *PresenceTracker) stopActivity(key activityKey, changes []PresenceChange) []PresenceChange {
+	current, ok := t.activities[key]
+	if !ok {
+		return changes
+	}
+
+	current.timer.Stop()
+	delete(t.activities, key)
+
+	return append(changes, PresenceChange{Kind: PresenceActivityStop, UserID: key.userID, PeerID: key.peerID, Activity: current.activity})
+}
+
+// Завершает истекшее действие
+func (t *PresenceTracker) expire(key activityKey, expired *activity) {
+	t.mx.Lock()
+	// Действие могло быть продлено или завершено, пока таймер ждал блокировку
+	if t.activities[key] != expired || time.Now().Before(expired.deadline) {
+		t.mx.Unlock()
+		return
+	}
+	changes := t.stopActivity(key, nil)
+	t.mx.Unlock()
+
+	t.subscribers.notify(changes...)
+}
+
+// Возвращает присутствие пользователя, false - если о пользователе не было событий
+func (t *PresenceTracker) Presence(userID int64) (Presence, bool) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	presence, ok := t.presence[userID]
+	return presence, ok
+}
+
+// Проверяет, в сети ли пользователь
+func (t *PresenceTracker) Online(userID int64) bool {
+	presence, _ := t.Presence(userID)
+	return presence.Online
+}
+
+// Возвращает текущее действие пользователя в диалоге
+func (t *PresenceTracker) Activity(userID, peerID int64) (Activity, bool) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	current, ok := t.activities[activityKey{userID: userID, peerID: peerID}]
+	if !ok {
+		return "", false
+	}
+	return current.activity, true
+}
+
+// Проверяет, набирает ли пользователь текст в диалоге
+func (t *PresenceTracker) Typing(userID, peerID int64) bool {
+	act, ok := t.Activity(userID, peerID)
+	return ok && act == ActivityTyping
+}
+
+// Возвращает отсортированный список пользователей, которые сейчас выполняют действие в диалоге
+func (t *PresenceTracker) ActiveIn(peerID int64) []int64 {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	users := []int64{}
+	for key := range t.activities {
+		if key.peerID == peerID {
+			users = append(users, key.userID)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i] < users[j]
+	})
+
+	return users
+}
+
+// Подписывает fn на изменения присутствия, возвращает функцию отписки
+// fn вызывается из горутины, вызвавшей Handle, или из таймера при истечении действия
+func (t *PresenceTracker) Subscribe(fn func(change PresenceChange)) (unsubscribe func()) {
+	return t.subscribers.add(fn)
+}
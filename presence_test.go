@@ -0,0 +1,95 @@
+package vklongpoll_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	tracker := vklongpoll.NewPresenceTracker(100 * time.Millisecond)
+
+	changesMx := sync.Mutex{}
+	changes := []vklongpoll.PresenceChange{}
+	tracker.Subscribe(func(change vklongpoll.PresenceChange) {
+		changesMx.Lock()
+		defer changesMx.Unlock()
+		changes = append(changes, change)
+	})
+
+	tracker.Handle([]vklongpoll.Update{
+		vklongpoll.Update(`[8, -123, 7, 1700000000]`),
+		vklongpoll.Update(`[8, -456, 260, 1700000000]`),
+		vklongpoll.Update(`[9, -456, 1, 1700000000]`),
+		vklongpoll.Update(`[61, 123, 1]`),
+		vklongpoll.Update(`[62, 456, 5]`),
+		vklongpoll.Update(`[64, 2000000001, [789], 1, 1700000000]`),
+		vklongpoll.Update(`{"type": "message_typing_state", "object": {"state": "typing", "from_id": 321, "to_id": -1}}`),
+	})
+
+	if presence, ok := tracker.Presence(123); !ok || !presence.Online || presence.Platform != vklongpoll.PlatformWeb {
+		t.Errorf("expected user 123 to be online from web but got %+v", presence)
+	}
+
+	if tracker.Online(456) {
+		t.Error("expected user 456 to be offline")
+	}
+
+	if !tracker.Typing(123, 123) || !tracker.Typing(456, 2000000005) || !tracker.Typing(321, 321) {
+		t.Error("expected users to be typing")
+	}
+
+	if act, _ := tracker.Activity(789, 2000000001); act != vklongpoll.ActivityAudioMessage {
+		t.Errorf("expected user 789 to record audio but got %q", act)
+	}
+
+	if users := tracker.ActiveIn(2000000001); !reflect.DeepEqual(users, []int64{789}) {
+		t.Errorf("unexpected active users: %v", users)
+	}
+
+	// Отправленное сообщение завершает набор текста сразу
+	tracker.Handle([]vklongpoll.Update{vklongpoll.Update(`[4, 10, 1, 123, 1700000001, "hi"]`)})
+	if tracker.Typing(123, 123) {
+		t.Error("expected typing to stop after message")
+	}
+
+	// Продление набора текста у пользователя 456
+	time.Sleep(60 * time.Millisecond)
+	tracker.Handle([]vklongpoll.Update{vklongpoll.Update(`[62, 456, 5]`)})
+	time.Sleep(60 * time.Millisecond)
+
+	if !tracker.Typing(456, 2000000005) {
+		t.Error("expected typing to be extended")
+	}
+
+	if tracker.Typing(321, 321) {
+		t.Error("expected typing to expire")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if users := tracker.ActiveIn(2000000005); len(users) != 0 {
+		t.Errorf("expected all activities to expire but got %v", users)
+	}
+
+	changesMx.Lock()
+	defer changesMx.Unlock()
+
+	kinds := map[vklongpoll.PresenceChangeKind]int{}
+	for _, change := range changes {
+		kinds[change.Kind]++
+	}
+
+	want := map[vklongpoll.PresenceChangeKind]int{
+		vklongpoll.PresenceOnline:        2,
+		vklongpoll.PresenceOffline:       1,
+		vklongpoll.PresenceActivityStart: 4,
+		vklongpoll.PresenceActivityStop:  4,
+	}
+
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("expected changes %v but got %v: %+v", want, kinds, changes)
+	}
+}
@@ -0,0 +1,304 @@
+package vklongpoll
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Способ выбора прокси из пула
+type ProxySelection int
+
+// Каждый запрос отправляется через следующий прокси
+const RoundRobin ProxySelection = 0
+
+// Все запросы отправляются через один прокси, пока он не перестанет отвечать
+const Sticky ProxySelection = 1
+
+// Время, на которое прокси исключается из выбора после ошибки
+var DefaultProxyCooldown = 30 * time.Second
+
+// Таймаут подключения к прокси по умолчанию
+var DefaultProxyConnectTimeout = 10 * time.Second
+
+// Пул прокси с переключением на следующий прокси в случае ошибки.
+// Реализует http.RoundTripper, поэтому подходит и для VkLongPoll.HttpClient, и для executor.Executor.HttpClient:
+//
+//	pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+//	pool.AddProxy(proxyUrl)
+//	lp.HttpClient = pool.Client()
+//	exec.HttpClient = pool.Client()
+type ProxyPool struct {
+	Cooldown       time.Duration // Время исключения прокси из выбора после ошибки
+	ConnectTimeout time.Duration // Таймаут подключения для прокси, добавленных через AddProxy
+	selection      ProxySelection
+	proxies        []*poolProxy
+	next           int // Индекс прокси для следующего запроса
+	mx             sync.Mutex
+}
+
+// Состояние прокси в пуле
+type ProxyHealth struct {
+	Name                string    // Имя прокси (хост без логина и пароля)
+	Healthy             bool      // Участвует ли прокси в выборе
+	ConsecutiveFailures int       // Количество ошибок подряд
+	LastError           error     // Последняя ошибка
+	LastUsed            time.Time // Время последнего успешного запроса
+}
+
+type poolProxy struct {
+	name      string
+	transport http.RoundTripper
+	failures  int
+	lastError error
+	lastUsed  time.Time
+	downUntil time.Time
+}
+
+type servedByContextKey struct{}
+
+// Создает пустой пул прокси
+func NewProxyPool(selection ProxySelection) *ProxyPool {
+	return &ProxyPool{
+		Cooldown:       DefaultProxyCooldown,
+		ConnectTimeout: DefaultProxyConnectTimeout,
+		selection:      selection,
+	}
+}
+
+// Добавляет прокси по URL (http, https или socks5)
+func (p *ProxyPool) AddProxy(proxyUrl *url.URL) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyUrl)
+	transport.DialContext = (&net.Dialer{Timeout: p.ConnectTimeout}).DialContext
+	transport.TLSHandshakeTimeout = p.ConnectTimeout
+
+	p.AddTransport(proxyUrl.Host, transport)
+}
+
+// Добавляет HTTP клиент как отдельный элемент пула
+// Используется транспорт клиента, остальные настройки клиента (таймаут, cookies) не учитываются
+func (p *ProxyPool) AddClient(name string, client *http.Client) {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	p.AddTransport(name, transport)
+}
+
+// Добавляет транспорт как отдельный элемент пула
+func (p *ProxyPool) AddTransport(name string, transport http.RoundTripper) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.proxies = append(p.proxies, &poolProxy{
+		name:      name,
+		transport: transport,
+	})
+}
+
+// Возвращает HTTP клиент, который отправляет запросы через пул
+func (p *ProxyPool) Client() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+// Возвращает состояние всех прокси пула
+func (p *ProxyPool) Health() []ProxyHealth {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	now := time.Now()
+	health := make([]ProxyHealth, len(p.proxies))
+	for i, proxy := range p.proxies {
+		health[i] = ProxyHealth{
+			Name:                proxy.name,
+			Healthy:             !now.Before(proxy.downUntil),
+			ConsecutiveFailures: proxy.failures,
+			LastError:           proxy.lastError,
+			LastUsed:            proxy.lastUsed,
+		}
+	}
+
+	return health
+}
+
+// Отправляет запрос через первый доступный прокси, при ошибке переходит к следующему
+// Неидемпотентный запрос (например, POST к messages.send) повторяется через другой прокси,
+// только если он точно не дошел до сервера: прокси не удалось подключиться или прокси потребовал авторизацию
+func (p *ProxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	candidates := p.candidates()
+	if len(candidates) == 0 {
+		return nil, errors.New("proxy pool is empty")
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	replayable := isReplayable(req)
+
+	var lastErr error
+	for _, proxy := range candidates {
+		attempt := req.Clone(req.Context())
+		if body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := proxy.transport.RoundTrip(attempt)
+		if err == nil && !isProxyFailureStatus(res.StatusCode) {
+			p.markSuccess(proxy)
+			setServedBy(req.Context(), proxy.name)
+			return res, nil
+		}
+
+		if req.Context().Err() != nil {
+			if err == nil {
+				res.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+
+		// Запрос точно не дошел до сервера, если прокси не удалось подключиться или прокси потребовал авторизацию
+		notSent := isConnectError(err)
+		if err == nil {
+			notSent = res.StatusCode == http.StatusProxyAuthRequired
+			err = fmt.Errorf("proxy responded with status %d", res.StatusCode)
+		}
+
+		p.markFailure(proxy, err)
+
+		// Прокси мог передать запрос серверу, прежде чем вернуть 502 или 504, поэтому ответ возвращается как есть
+		if res != nil && !replayable && !notSent {
+			setServedBy(req.Context(), proxy.name)
+			return res, nil
+		}
+
+		if res != nil {
+			res.Body.Close()
+		}
+
+		lastErr = fmt.Errorf("proxy %s: %w", proxy.name, err)
+		if !replayable && !notSent {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+// Возвращает прокси в порядке попыток: сначала доступные, затем исключенные после ошибки
+func (p *ProxyPool) candidates() []*poolProxy {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if len(p.proxies) == 0 {
+		return nil
+	}
+
+	start := p.next % len(p.proxies)
+	if p.selection == RoundRobin {
+		p.next = start + 1
+	}
+
+	now := time.Now()
+	healthy := make([]*poolProxy, 0, len(p.proxies))
+	down := []*poolProxy{}
+	for i := range p.proxies {
+		proxy := p.proxies[(start+i)%len(p.proxies)]
+		if now.Before(proxy.downUntil) {
+			down = append(down, proxy)
+		} else {
+			healthy = append(healthy, proxy)
+		}
+	}
+
+	return append(healthy, down...)
+}
+
+// Сбрасывает счетчик ошибок прокси, в режиме Sticky закрепляет его за следующими запросами
+func (p *ProxyPool) markSuccess(proxy *poolProxy) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	proxy.failures = 0
+	proxy.lastError = nil
+	proxy.lastUsed = time.Now()
+	proxy.downUntil = time.Time{}
+
+	if p.selection == Sticky {
+		for i := range p.proxies {
+			if p.proxies[i] == proxy {
+				p.next = i
+			}
+		}
+	}
+}
+
+// Исключает прокси из выбора на время Cooldown
+func (p *ProxyPool) markFailure(proxy *poolProxy, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	proxy.failures++
+	proxy.lastError = err
+	proxy.downUntil = time.Now().Add(p.Cooldown)
+}
+
+// Ошибки, которые возвращает сам прокси, а не сервер ВКонтакте
+func isProxyFailureStatus(status int) bool {
+	return status == http.StatusProxyAuthRequired ||
+		status == http.StatusBadGateway ||
+		status == http.StatusGatewayTimeout
+}
+
+// Можно ли повторить запрос через другой прокси, если он мог дойти до сервера
+// Как и в net/http, повторяются только идемпотентные методы и запросы с ключом идемпотентности
+func isReplayable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// Ошибка подключения к прокси: запрос еще не был отправлен
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+
+	return opErr.Op == "dial" || opErr.Op == "proxyconnect"
+}
+
+// Читает тело запроса, чтобы его можно было отправить повторно через другой прокси
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	defer req.Body.Close()
+	return io.ReadAll(req.Body)
+}
+
+// Возвращает контекст, в который пул запишет имя прокси, отправившего запрос
+func withServedBy(ctx context.Context) (context.Context, *string) {
+	servedBy := new(string)
+	return context.WithValue(ctx, servedByContextKey{}, servedBy), servedBy
+}
+
+// Записывает имя прокси в контекст запроса, если он был подготовлен через withServedBy
+func setServedBy(ctx context.Context, name string) {
+	if servedBy, ok := ctx.Value(servedByContextKey{}).(*string); ok {
+		*servedBy = name
+	}
+}
@@ -0,0 +1,196 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestProxyPool(t *testing.T) {
+	var deadCalls int
+	dead := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		deadCalls++
+		return nil, &net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("connection refused")}
+	})
+
+	t.Run("fails over to the next proxy", func(t *testing.T) {
+		serverUpdater, closeServers := startLongPollServers(t, []byte(`{"ts":"2","updates":[]}`))
+		defer closeServers()
+
+		pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+		pool.AddTransport("dead", dead)
+		pool.AddTransport("alive", http.DefaultTransport)
+
+		lp := vklongpoll.New()
+		lp.HttpClient = pool.Client()
+
+		res, err := lp.RecvResponse(context.Background(), serverUpdater)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Proxy != "alive" {
+			t.Errorf("expected poll served by %q but got %q", "alive", res.Proxy)
+		}
+
+		health := pool.Health()
+		if health[0].Healthy || health[0].ConsecutiveFailures != 1 || !health[1].Healthy {
+			t.Errorf("unexpected pool health: %+v", health)
+		}
+
+		deadCallsBefore := deadCalls
+		if _, err := lp.RecvResponse(context.Background(), serverUpdater); err != nil {
+			t.Fatal(err)
+		}
+
+		if deadCalls != deadCallsBefore {
+			t.Errorf("expected unhealthy proxy to be skipped")
+		}
+	})
+
+	t.Run("retries request body", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		}))
+		defer server.Close()
+
+		pool := vklongpoll.NewProxyPool(vklongpoll.Sticky)
+		pool.AddTransport("dead", dead)
+		pool.AddClient("alive", http.DefaultClient)
+
+		res, err := pool.Client().Post(server.URL, "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(res.Body)
+		if string(body) != "body" {
+			t.Errorf("expected body %q but got %q", "body", body)
+		}
+	})
+
+	t.Run("all proxies failed", func(t *testing.T) {
+		pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+		pool.AddTransport("dead", dead)
+
+		_, err := pool.Client().Get("http://example.com")
+		if err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+	t.Run("does not repeat non-idempotent request after bad gateway", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+		}))
+		defer server.Close()
+
+		// Прокси передал запрос серверу, но не дождался ответа
+		badGateway := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			res, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			res.StatusCode = http.StatusBadGateway
+			return res, nil
+		})
+
+		pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+		pool.AddTransport("bad gateway", badGateway)
+		pool.AddTransport("alive", http.DefaultTransport)
+
+		res, err := pool.Client().Post(server.URL, "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected status %d but got %d", http.StatusBadGateway, res.StatusCode)
+		}
+
+		if calls != 1 {
+			t.Errorf("expected 1 request to server but got %d", calls)
+		}
+
+		if health := pool.Health(); health[0].Healthy {
+			t.Errorf("expected proxy to be marked unhealthy: %+v", health)
+		}
+
+	})
+
+	t.Run("repeats request rejected by proxy auth", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		authRequired := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusProxyAuthRequired, Body: http.NoBody}, nil
+		})
+
+		pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+		pool.AddTransport("auth required", authRequired)
+		pool.AddTransport("alive", http.DefaultTransport)
+
+		res, err := pool.Client().Post(server.URL, "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("expected status %d but got %d", http.StatusOK, res.StatusCode)
+		}
+	})
+
+	t.Run("closes failed response after cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		body := &closeRecorder{Reader: strings.NewReader("")}
+		cancelled := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			cancel()
+			return &http.Response{StatusCode: http.StatusGatewayTimeout, Body: body}, nil
+		})
+
+		pool := vklongpoll.NewProxyPool(vklongpoll.RoundRobin)
+		pool.AddTransport("cancelled", cancelled)
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+		res, err := pool.RoundTrip(req)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled but got %v", err)
+		}
+
+		if res != nil {
+			t.Errorf("expected nil response but got %+v", res)
+		}
+
+		if !body.closed {
+			t.Errorf("expected response body to be closed")
+		}
+	})
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
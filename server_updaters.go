@@ -0,0 +1,59 @@
+package vklongpoll
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+)
+
+// Параметры запроса messages.getLongPollServer
+type MessagesServerOptions struct {
+	GroupID   int  // Идентификатор сообщества для получения Long Poll сообщений сообщества через токен пользователя
+	LpVersion int  // Версия Long Poll, используется только при вызове вне VkLongPoll
+	NeedPts   bool // Вернуть pts, используется только при вызове вне VkLongPoll
+}
+
+// Обработчик обновления сервера для Bots Long Poll API (groups.getLongPollServer)
+func GroupsServerUpdater(exec *executor.Executor, token string, groupID int) ServerUpdater {
+	return func(ctx context.Context) (*ServerCredentials, error) {
+		req := request.New()
+		req.Method("groups.getLongPollServer")
+		req.GetParams().AccessToken(token)
+		req.GetParams().Set("group_id", strconv.Itoa(groupID))
+
+		return UniversalServerUpdater(req, exec)(ctx)
+	}
+}
+
+// Обработчик обновления сервера для Long Poll пользователя (messages.getLongPollServer)
+// Внутри VkLongPoll значение lp_version берется из опции Version, а need_pts - из режима ReturnPts,
+// поэтому параметры сервера всегда совпадают с параметрами запроса a_check
+func MessagesServerUpdater(exec *executor.Executor, token string, opts MessagesServerOptions) ServerUpdater {
+	return func(ctx context.Context) (*ServerCredentials, error) {
+		lpVersion, needPts := opts.LpVersion, opts.NeedPts
+		if opt := OptionsFromContext(ctx); opt != nil {
+			lpVersion = opt.Version
+			needPts = opt.Mode.Has(ReturnPts)
+		}
+
+		req := request.New()
+		req.Method("messages.getLongPollServer")
+		req.GetParams().AccessToken(token)
+
+		if lpVersion != 0 {
+			req.GetParams().Set("lp_version", strconv.Itoa(lpVersion))
+		}
+
+		if needPts {
+			req.GetParams().Set("need_pts", "1")
+		}
+
+		if opts.GroupID != 0 {
+			req.GetParams().Set("group_id", strconv.Itoa(opts.GroupID))
+		}
+
+		return UniversalServerUpdater(req, exec)(ctx)
+	}
+}
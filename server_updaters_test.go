@@ -0,0 +1,124 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestServerUpdaters(t *testing.T) {
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ts":2,"updates":[]}`))
+	}))
+
+	defer longPollServer.Close()
+
+	var apiPath string
+	var apiParams url.Values
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		apiPath = r.URL.Path
+		apiParams, _ = url.ParseQuery(string(body))
+
+		res, err := json.Marshal(map[string]interface{}{
+			"response": map[string]interface{}{
+				"server": longPollServer.URL,
+				"key":    "key",
+				"ts":     1,
+				"pts":    100,
+			},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(res)
+	}))
+
+	defer apiServer.Close()
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	exec := executor.New()
+
+	t.Run("groups server updater", func(t *testing.T) {
+		lp := vklongpoll.New()
+		_, err := lp.Recv(context.Background(),
+			vklongpoll.WithServerUpdater(vklongpoll.GroupsServerUpdater(exec, "token", 123)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if apiPath != "/groups.getLongPollServer" {
+			t.Errorf("expected method groups.getLongPollServer but got %q", apiPath)
+		}
+
+		if apiParams.Get("group_id") != "123" || apiParams.Get("access_token") != "token" {
+			t.Errorf("unexpected request params: %v", apiParams)
+		}
+	})
+
+	t.Run("messages server updater follows options", func(t *testing.T) {
+		lp := vklongpoll.New()
+		_, err := lp.Recv(context.Background(),
+			vklongpoll.WithServerUpdater(vklongpoll.MessagesServerUpdater(exec, "token", vklongpoll.MessagesServerOptions{
+				GroupID:   123,
+				LpVersion: 3,
+			})),
+			vklongpoll.WithVersion(10),
+			vklongpoll.WithMode(vklongpoll.ReturnPts),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if apiPath != "/messages.getLongPollServer" {
+			t.Errorf("expected method messages.getLongPollServer but got %q", apiPath)
+		}
+
+		expectedParams := map[string]string{"lp_version": "10", "need_pts": "1", "group_id": "123"}
+		for key, val := range expectedParams {
+			if apiParams.Get(key) != val {
+				t.Errorf("expected param %s=%q but got %q", key, val, apiParams.Get(key))
+			}
+		}
+
+	})
+
+	t.Run("messages server updater returns pts", func(t *testing.T) {
+		creds, err := vklongpoll.MessagesServerUpdater(exec, "token", vklongpoll.MessagesServerOptions{NeedPts: true})(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if apiParams.Get("need_pts") != "1" {
+			t.Errorf("expected need_pts param outside long poll")
+		}
+
+		if creds.Pts == nil || *creds.Pts != 100 {
+			t.Errorf("expected pts from server credentials but got %v", creds.Pts)
+		}
+	})
+
+	t.Run("messages server updater without need_pts", func(t *testing.T) {
+		lp := vklongpoll.New()
+		_, err := lp.Recv(context.Background(),
+			vklongpoll.WithServerUpdater(vklongpoll.MessagesServerUpdater(exec, "token", vklongpoll.MessagesServerOptions{NeedPts: true})),
+			vklongpoll.WithMode(vklongpoll.Attachments),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if apiParams.Has("need_pts") || apiParams.Has("group_id") {
+			t.Errorf("unexpected request params: %v", apiParams)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package vklongpoll
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type rejectedCredentialsContextKey struct{}
+
+// Возвращает данные сервера, которые отклонил Long Poll сервер (failed=2 или failed=3)
+// Если ServerUpdater вызван при первом подключении, вернет nil
+func RejectedCredentialsFromContext(ctx context.Context) *ServerCredentials {
+	creds, _ := ctx.Value(rejectedCredentialsContextKey{}).(*ServerCredentials)
+	return creds
+}
+
+// Сохраняет отклоненные данные сервера в контекст
+func withRejectedCredentials(ctx context.Context, creds *ServerCredentials) context.Context {
+	return context.WithValue(ctx, rejectedCredentialsContextKey{}, creds)
+}
+
+// Максимальная длительность общего запроса данных сервера в SharedServerUpdater
+var DefaultSharedFetchTimeout = 30 * time.Second
+
+// Контекст, который передает значения родительского контекста, но не его отмену и дедлайн
+// Нужен общему запросу: отмена контекста первого вызова не должна прерывать запрос для остальных
+type valueOnlyCtx struct {
+	context.Context
+}
+
+func (valueOnlyCtx) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (valueOnlyCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueOnlyCtx) Err() error {
+	return nil
+}
+
+// Общий кеш данных сервера для SharedServerUpdater
+type sharedCredentials struct {
+	updater  ServerUpdater
+	ttl      time.Duration
+	creds    *ServerCredentials
+	expires  time.Time
+	inFlight *sharedCall
+	mx       sync.Mutex
+}
+
+// Запрос данных сервера, результат которого ждут все одновременные вызовы
+type sharedCall struct {
+	done  chan struct{}
+	creds *ServerCredentials
+	err   error
+}
+
+// Оборачивает ServerUpdater для использования в нескольких соединениях VkLongPoll с одним токеном
+// Одновременные вызовы объединяются в один запрос, а полученные данные кешируются на ttl.
+// Новый запрос делается, только если кеш устарел или Long Poll сервер отклонил именно закешированный ключ
+// (ForceServerRefresh и ResetToLatest тоже отклоняют текущий ключ соединения).
+// Запрос выполняется с отдельным контекстом (значения сохраняются, но не отмена), поэтому отмена одного вызова
+// не прерывает его для остальных, а каждый вызов ждет результат не дольше своего ctx.
+// Данные из кеша помечаются как Cached: их ts не заменяет более новый ts соединения
+func SharedServerUpdater(updater ServerUpdater, ttl time.Duration) ServerUpdater {
+	shared := &sharedCredentials{
+		updater: updater,
+		ttl:     ttl,
+	}
+
+	return shared.get
+}
+
+// Возвращает закешированные данные сервера или запрашивает новые
+func (s *sharedCredentials) get(ctx context.Context) (*ServerCredentials, error) {
+	s.mx.Lock()
+
+	if s.creds != nil && time.Now().Before(s.expires) {
+		rejected := RejectedCredentialsFromContext(ctx)
+		if rejected == nil || rejected.Key != s.creds.Key {
+			creds := copyCredentials(s.creds)
+			creds.Cached = true
+			s.mx.Unlock()
+			return creds, nil
+		}
+	}
+
+	call := s.inFlight
+	if call == nil {
+		call = &sharedCall{done: make(chan struct{})}
+		s.inFlight = call
+		go s.fetch(valueOnlyCtx{ctx}, call)
+	}
+
+	s.mx.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return copyCredentials(call.creds), nil
+}
+
+// Запрашивает данные сервера и сохраняет их в кеш
+func (s *sharedCredentials) fetch(ctx context.Context, call *sharedCall) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultSharedFetchTimeout)
+	defer cancel()
+
+	call.creds, call.err = s.updater(ctx)
+
+	s.mx.Lock()
+	if call.err == nil {
+		s.creds = call.creds
+		s.expires = time.Now().Add(s.ttl)
+	}
+	s.inFlight = nil
+	s.mx.Unlock()
+
+	close(call.done)
+}
+
+// Копирует данные сервера, чтобы соединения не изменяли общий URL
+func copyCredentials(creds *ServerCredentials) *ServerCredentials {
+	c := *creds
+	if creds.ServerURL != nil {
+		serverUrl := *creds.ServerURL
+		c.ServerURL = &serverUrl
+	}
+	return &c
+}
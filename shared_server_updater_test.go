@@ -0,0 +1,148 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestSharedServerUpdater(t *testing.T) {
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") == "key1" {
+			w.Write([]byte(`{"failed":2}`))
+			return
+		}
+		w.Write([]byte(`{"ts":2,"updates":[]}`))
+	}))
+
+	defer longPollServer.Close()
+
+	var calls int32
+	updater := func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		call := atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+
+		serverUrl, _ := url.Parse(longPollServer.URL)
+		return &vklongpoll.ServerCredentials{
+			Ts:        1,
+			ServerURL: serverUrl,
+			Key:       "key" + strconv.Itoa(int(call)),
+		}, nil
+	}
+
+	t.Run("concurrent calls collapsed", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		shared := vklongpoll.SharedServerUpdater(updater, time.Minute)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := shared(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if calls != 1 {
+			t.Errorf("expected 1 server update but got %d", calls)
+		}
+	})
+
+	t.Run("refetch only rejected key", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		serverUpdater := vklongpoll.WithServerUpdater(vklongpoll.SharedServerUpdater(updater, time.Minute))
+
+		// Первое соединение получает key1, сервер его отклоняет, и кеш обновляется на key2
+		vklongpoll.New().Recv(context.Background(), serverUpdater)
+
+		if _, err := vklongpoll.New().Recv(context.Background(), serverUpdater); err != nil {
+			t.Error(err)
+		}
+
+		if calls != 2 {
+			t.Errorf("expected 2 server updates but got %d", calls)
+		}
+	})
+
+	t.Run("reset bypasses cache", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		serverUpdater := vklongpoll.WithServerUpdater(vklongpoll.SharedServerUpdater(updater, time.Minute))
+
+		lp := vklongpoll.New()
+		for i := 0; i < 2; i++ {
+			if _, err := lp.Recv(context.Background(), serverUpdater); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// В кеше лежит key2 с ts=1, но ResetToLatest запрашивает данные сервера заново и берет ts из них
+		lp.ResetToLatest()
+		res, err := lp.RecvResponse(context.Background(), serverUpdater)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls != 3 {
+			t.Errorf("expected 3 server updates but got %d", calls)
+		}
+
+		if res.PrevTs != 1 {
+			t.Errorf("expected request with ts 1 but got %d", res.PrevTs)
+		}
+	})
+
+	t.Run("cancelled caller does not cancel shared fetch", func(t *testing.T) {
+		slowUpdater := func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return updater(ctx)
+		}
+
+		shared := vklongpoll.SharedServerUpdater(slowUpdater, time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := shared(ctx)
+			errs <- err
+		}()
+
+		time.Sleep(time.Millisecond)
+		if _, err := shared(context.Background()); err != nil {
+			t.Errorf("expected shared fetch to succeed but got %v", err)
+		}
+
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Errorf("expected first caller to time out but got %v", err)
+		}
+	})
+
+	t.Run("cache expires", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		shared := vklongpoll.SharedServerUpdater(updater, time.Millisecond)
+
+		shared(context.Background())
+		time.Sleep(5 * time.Millisecond)
+		shared(context.Background())
+
+		if calls != 2 {
+			t.Errorf("expected 2 server updates but got %d", calls)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package vklongpoll
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Пачка событий одного ответа Long Poll сервера
+type Batch struct {
+	ReceivedAt time.Time // Время получения ответа
+	Source     string    // Обработчик, который получил данные сервера (ServerCredentials.Source)
+	PrevTs     int64     // Значение ts, с которым были получены события
+	Ts         int64     // Значение ts после этой пачки
+	Updates    []Update  // Все полученные события (до применения Filter)
+}
+
+// Получатель событий, вызывается до того, как Recv продвинет ts
+// Если получатель вернул ошибку, ts не меняется, а Recv возвращает ошибку:
+// следующий запрос получит те же события еще раз.
+// Массив Updates можно хранить после вызова (Filter применяется к копии),
+// но в режиме ZeroCopy сами события действительны только во время вызова
+type UpdatesSink func(ctx context.Context, batch *Batch) error
+
+// Добавляет получателя событий (например, Archive.Write)
+// Получатели вызываются по очереди в порядке добавления
+func WithSink(sink UpdatesSink) VkLongPollOption {
+	return func(v *VkLongPollOptions) {
+		v.Sinks = append(v.Sinks, sink)
+	}
+}
+
+// Передает пачку событий получателям, вызывается под блокировкой mx
+func (v *VkLongPoll) writeSinks(ctx context.Context, opt *VkLongPollOptions, batch *Batch) error {
+	for _, sink := range opt.Sinks {
+		if err := sink(ctx, batch); err != nil {
+			return fmt.Errorf("sink error: %w", err)
+		}
+	}
+	return nil
+}
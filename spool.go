@@ -0,0 +1,479 @@
+package vklongpoll
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Размер журнала, после которого он сжимается, по умолчанию
+var DefaultSpoolCompactSize int64 = 1 << 20
+
+// Ошибка работы с закрытым журналом
+var ErrSpoolClosed = errors.New("spool closed")
+
+// Параметры журнала
+type SpoolOptions struct {
+	CompactSize int64 // Размер журнала, после которого из него удаляются обработанные пачки (0 - DefaultSpoolCompactSize)
+	NoResume    bool  // Не продолжать с последнего сохраненного ts после перезапуска
+}
+
+// Пачка событий из журнала
+type SpoolBatch struct {
+	Batch
+	ID       uint64 // Номер пачки в журнале (0 - пачка без событий, ее не нужно подтверждать)
+	Replayed bool   // Пачка восстановлена из журнала после перезапуска
+}
+
+// Запись журнала
+type spoolRecord struct {
+	Op         string            `json:"op"` // write - пачка событий, done - пачка обработана, ts - последний ts
+	ID         uint64            `json:"id,omitempty"`
+	ReceivedAt time.Time         `json:"received_at"`
+	Source     string            `json:"source,omitempty"`
+	PrevTs     int64             `json:"prev_ts,omitempty"`
+	Ts         int64             `json:"ts,omitempty"`
+	Updates    []json.RawMessage `json:"updates,omitempty"`
+}
+
+// Отметка в журнале (без полей пачки)
+type spoolMark struct {
+	Op string `json:"op"`
+	ID uint64 `json:"id,omitempty"`
+	Ts int64  `json:"ts,omitempty"`
+}
+
+// Локальный журнал (write-ahead log) между получением и обработкой событий
+// Пачка событий записывается в журнал (с fsync) до того, как Recv продвинет ts, и остается в нем,
+// пока обработчик не вызовет Done. После перезапуска необработанные пачки возвращаются Recv повторно,
+// а получение продолжается с последнего сохраненного ts. Так каждое событие обрабатывается хотя бы один раз
+type Spool struct {
+	path    string
+	opts    SpoolOptions
+	file    *os.File
+	size    int64
+	nextID  uint64
+	pending map[uint64][]byte // Строки журнала необработанных пачек
+	replay  []*SpoolBatch     // Необработанные пачки после перезапуска
+	lastTs  *int64            // Последний сохраненный ts
+	resumed bool
+	closed  bool
+	owners  map[*VkLongPoll]bool // Соединения, которые закроют журнал в Close
+	mx      sync.Mutex
+}
+
+// Открывает журнал в файле path и восстанавливает необработанные пачки
+// Недописанная последняя строка (после сбоя) отбрасывается, а поврежденная строка в середине журнала возвращает ошибку
+func OpenSpool(path string, opts SpoolOptions) (*Spool, error) {
+	if opts.CompactSize <= 0 {
+		opts.CompactSize = DefaultSpoolCompactSize
+	}
+
+	s := &Spool{path: path, opts: opts, nextID: 1, pending: map[uint64][]byte{}, owners: map[*VkLongPoll]bool{}}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Читает журнал
+func (s *Spool) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	batches := map[uint64]*SpoolBatch{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		record := spoolRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			// Недописанной может быть только последняя строка, повреждение в середине не перезаписываем при сжатии
+			if scanner.Scan() {
+				return fmt.Errorf("spool %s: corrupted record at line %d: %w", s.path, lineNum, err)
+			}
+			break
+		}
+
+		switch record.Op {
+		case "write":
+			updates := make([]Update, len(record.Updates))
+			for i, update := range record.Updates {
+				updates[i] = Update(update)
+			}
+
+			batches[record.ID] = &SpoolBatch{
+				ID:       record.ID,
+				Replayed: true,
+				Batch: Batch{
+					ReceivedAt: record.ReceivedAt,
+					Source:     record.Source,
+					PrevTs:     record.PrevTs,
+					Ts:         record.Ts,
+					Updates:    updates,
+				},
+			}
+			s.pending[record.ID] = append(append([]byte(nil), line...), '\n')
+			s.setTs(record.Ts)
+		case "done":
+			delete(batches, record.ID)
+			delete(s.pending, record.ID)
+		case "ts":
+			s.setTs(record.Ts)
+		}
+
+		if record.ID >= s.nextID {
+			s.nextID = record.ID + 1
+		}
+	}
+
+	for _, batch := range batches {
+		s.replay = append(s.replay, batch)
+	}
+
+	sort.Slice(s.replay, func(i, j int) bool {
+		return s.replay[i].ID < s.replay[j].ID
+	})
+
+	return nil
+}
+
+// Запоминает последний ts, вызывается под блокировкой
+func (s *Spool) setTs(ts int64) {
+	s.lastTs = &ts
+}
+
+// Возвращает последний сохраненный ts
+func (s *Spool) LastTs() (int64, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.lastTs == nil {
+		return 0, false
+	}
+	return *s.lastTs, true
+}
+
+// Возвращает количество необработанных пачек
+func (s *Spool) Pending() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return len(s.pending)
+}
+
+// Возвращает следующую пачку событий
+// Сначала возвращаются необработанные пачки из журнала (к ним применяется Filter из opts),
+// затем события запрашиваются у lp, а каждая полученная пачка записывается в журнал до продвижения ts.
+// После обработки пачки вызовите Done. Журнал закрывается при закрытии lp (Close)
+func (s *Spool) Recv(ctx context.Context, lp *VkLongPoll, opts ...VkLongPollOption) (*SpoolBatch, error) {
+	opt := BuildOptions(opts...)
+
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return nil, ErrSpoolClosed
+	}
+
+	if len(s.replay) != 0 {
+		batch := s.replay[0]
+		s.replay = s.replay[1:]
+		s.mx.Unlock()
+
+		replayed := *batch
+		replayed.Updates = append([]Update(nil), batch.Updates...)
+		if opt.Filter != nil {
+			replayed.Updates = opt.Filter.Apply(replayed.Updates)
+		}
+		return &replayed, nil
+	}
+
+	if !s.resumed && !s.opts.NoResume && s.lastTs != nil {
+		lp.SetTs(*s.lastTs)
+	}
+	s.resumed = true
+
+	owned := s.owners[lp]
+	s.owners[lp] = true
+	s.mx.Unlock()
+
+	// Журнал сохраняется на диск и закрывается вместе с соединением
+	if !owned {
+		if err := lp.AddCloser(s); err != nil {
+			return nil, ErrClosed
+		}
+	}
+
+	// Пачка, записанная в журнал во время этого вызова
+	written := &SpoolBatch{Batch: Batch{ReceivedAt: time.Now()}}
+	opt.Sinks = append(opt.Sinks[:len(opt.Sinks):len(opt.Sinks)], func(ctx context.Context, batch *Batch) (err error) {
+		written.Batch = *batch
+		written.ID, err = s.write(batch)
+		return err
+	})
+
+	res, err := lp.RecvResponseOpt(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	written.PrevTs, written.Ts, written.Updates = res.PrevTs, res.Ts, res.Updates
+
+	// Пачки без событий не проходят через sink, но ts все равно нужно сохранить (в том числе после failed=1)
+	if written.ID == 0 {
+		if err := s.markTs(res.Ts); err != nil {
+			return nil, err
+		}
+	}
+
+	return written, nil
+}
+
+// Сохраняет в журнал ts, полученный без событий
+// Отметка не синхронизируется с диском сразу: при сбое события будут запрошены с предыдущего ts
+func (s *Spool) markTs(ts int64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+
+	if s.lastTs != nil && *s.lastTs == ts {
+		return nil
+	}
+
+	line, err := json.Marshal(spoolMark{Op: "ts", Ts: ts})
+	if err != nil {
+		return err
+	}
+
+	if err := s.append(append(line, '\n')); err != nil {
+		return err
+	}
+
+	s.setTs(ts)
+
+	if s.size >= s.opts.CompactSize {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// Записывает пачку в журнал и синхронизирует его с диском, возвращает номер пачки
+func (s *Spool) write(batch *Batch) (uint64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return 0, ErrSpoolClosed
+	}
+
+	record := spoolRecord{
+		Op:         "write",
+		ID:         s.nextID,
+		ReceivedAt: batch.ReceivedAt,
+		Source:     batch.Source,
+		PrevTs:     batch.PrevTs,
+		Ts:         batch.Ts,
+		Updates:    make([]json.RawMessage, len(batch.Updates)),
+	}
+
+	for i, update := range batch.Updates {
+		record.Updates[i] = json.RawMessage(update)
+	}
+
+	line, err := encodeSpoolLine(record)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.append(line); err != nil {
+		return 0, err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		// Пачка не сохранена на диск, убираем ее из журнала
+		s.compact()
+		return 0, err
+	}
+
+	s.pending[record.ID] = line
+	s.nextID++
+	s.setTs(batch.Ts)
+
+	return record.ID, nil
+}
+
+// Отмечает пачку обработанной, после этого она не будет восстановлена при перезапуске
+// Отметка не синхронизируется с диском сразу: при сбое пачка может быть обработана повторно
+func (s *Spool) Done(id uint64) error {
+	if id == 0 {
+		return nil
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+
+	if _, ok := s.pending[id]; !ok {
+		return nil
+	}
+
+	line, err := json.Marshal(spoolMark{Op: "done", ID: id})
+	if err != nil {
+		return err
+	}
+
+	if err := s.append(append(line, '\n')); err != nil {
+		return err
+	}
+
+	delete(s.pending, id)
+
+	if s.size >= s.opts.CompactSize {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// Удаляет из журнала обработанные пачки
+func (s *Spool) Compact() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+
+	return s.compact()
+}
+
+// Закрывает журнал, необработанные пачки остаются в нем до следующего запуска
+func (s *Spool) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Кодирует запись журнала в строку с переводом строки в конце
+// События сохраняются без экранирования HTML (<, > и & не заменяются на \u003c и т.д.)
+func encodeSpoolLine(record spoolRecord) ([]byte, error) {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Дописывает строку в журнал, вызывается под блокировкой
+func (s *Spool) append(line []byte) error {
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	if err != nil {
+		// Убираем из журнала недописанную строку, иначе следующие записи окажутся на ней
+		// и журнал не откроется после перезапуска
+		s.compact()
+	}
+	return err
+}
+
+// Перезаписывает журнал (через временный файл): последний ts и необработанные пачки
+// Вызывается под блокировкой
+func (s *Spool) compact() error {
+	buf := bytes.Buffer{}
+
+	if s.lastTs != nil {
+		line, err := json.Marshal(spoolMark{Op: "ts", Ts: *s.lastTs})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	ids := make([]uint64, 0, len(s.pending))
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		buf.Write(s.pending[id])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.size = int64(buf.Len())
+	return nil
+}
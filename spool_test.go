@@ -0,0 +1,211 @@
+package vklongpoll_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestSpool(t *testing.T) {
+	// Текст с символами, которые encoding/json по умолчанию экранирует
+	body := bytes.ReplaceAll(longPollBatch(t, 3), []byte("Hello, world!"), []byte("<b>&</b>"))
+	serverUpdater, closeServers := startLongPollServers(t, body)
+	defer closeServers()
+
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	spool, err := vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lp := vklongpoll.New()
+
+	first, err := spool.Recv(context.Background(), lp, serverUpdater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID != 1 || first.Replayed || len(first.Updates) != 3 || first.PrevTs != 1 || first.Ts != 2 || lp.Ts != 2 {
+		t.Errorf("unexpected first batch: %+v, ts %d", first, lp.Ts)
+	}
+
+	second, err := spool.Recv(context.Background(), lp, serverUpdater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spool.Done(second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Первая пачка не обработана, процесс падает посреди записи
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"op": "write", "id": 3, "upd`))
+	file.Close()
+
+	spool, err = vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	if ts, ok := spool.LastTs(); !ok || ts != 2 || spool.Pending() != 1 {
+		t.Errorf("unexpected spool state: ts %d, %d pending", ts, spool.Pending())
+	}
+
+	lp = vklongpoll.New()
+	filter := vklongpoll.MustCompileFilter(`event_id != "1"`)
+
+	replayed, err := spool.Recv(context.Background(), lp, serverUpdater, vklongpoll.WithFilter(filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.ID != first.ID || !replayed.Replayed || len(replayed.Updates) != 2 || replayed.Ts != 2 {
+		t.Errorf("unexpected replayed batch: %+v", replayed)
+	}
+
+	if !bytes.Equal(replayed.Updates[1], first.Updates[2]) {
+		t.Errorf("unexpected replayed update: %s", replayed.Updates[1])
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte(`\u0026`)) || !bytes.Contains(data, []byte(`<b>&</b>`)) {
+		t.Errorf("spool escapes updates: %s", data)
+	}
+
+	if err := spool.Done(replayed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Получение продолжается с сохраненного ts, а не с ts из ServerUpdater
+	next, err := spool.Recv(context.Background(), lp, serverUpdater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.ID != 3 || next.Replayed || next.PrevTs != 2 {
+		t.Errorf("unexpected next batch: %+v", next)
+	}
+}
+
+func TestSpoolCompaction(t *testing.T) {
+	serverUpdater, closeServers := startLongPollServers(t, longPollBatch(t, 3))
+	defer closeServers()
+
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	spool, err := vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{CompactSize: 1, NoResume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	lp := vklongpoll.New()
+	batches := []*vklongpoll.SpoolBatch{}
+	for i := 0; i < 3; i++ {
+		batch, err := spool.Recv(context.Background(), lp, serverUpdater)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch)
+	}
+
+	for _, batch := range batches[:2] {
+		if err := spool.Done(batch.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// После сжатия остаются последний ts и необработанная пачка
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 || !bytes.Contains(lines[0], []byte(`"op":"ts"`)) || !bytes.Contains(lines[1], []byte(`"id":3`)) {
+		t.Errorf("unexpected compacted spool: %s", data)
+	}
+
+	if spool.Pending() != 1 {
+		t.Errorf("expected 1 pending batch but got %d", spool.Pending())
+	}
+}
+
+func TestSpoolEmptyBatchTs(t *testing.T) {
+	serverUpdater, closeServers := startLongPollServers(t, []byte(`{"ts":"5","updates":[]}`))
+	defer closeServers()
+
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	spool, err := vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := spool.Recv(context.Background(), vklongpoll.New(), serverUpdater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batch.ID != 0 || batch.Ts != 5 {
+		t.Errorf("unexpected batch: %+v", batch)
+	}
+
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ts пачки без событий сохраняется, иначе после перезапуска события запросятся с устаревшего ts
+	spool, err = vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	if ts, ok := spool.LastTs(); !ok || ts != 5 {
+		t.Errorf("expected saved ts 5 but got %d", ts)
+	}
+}
+
+func TestSpoolCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	data := []byte(`{"op":"ts","ts":1}` + "\n" +
+		`{"op":"write","id":1,"upd` + "\n" +
+		`{"op":"write","id":2,"received_at":"2024-01-01T00:00:00Z","ts":3,"updates":[[4]]}` + "\n")
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vklongpoll.OpenSpool(path, vklongpoll.SpoolOptions{}); err == nil {
+		t.Fatal("expected error for corrupted spool")
+	}
+
+	// Журнал не сжимается поверх поврежденной строки
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(saved, data) {
+		t.Errorf("expected spool to be left intact but got %s", saved)
+	}
+}
@@ -0,0 +1,40 @@
+package vklongpoll
+
+// Состояние Long Poll соединения
+type State struct {
+	Ts                int64  `json:"ts"`                           // Текущее значение ts
+	Pts               *Pts   `json:"pts,omitempty"`                // Последнее полученное значение pts
+	Version           int    `json:"version,omitempty"`            // Версия Long Poll (с учетом согласования)
+	Server            string `json:"server,omitempty"`             // URL Long Poll сервера без параметров
+	CredentialsSource string `json:"credentials_source,omitempty"` // Обработчик, который получил текущие данные сервера
+}
+
+// Возвращает состояние соединения
+// В отличие от полей VkLongPoll, безопасно вызывать во время выполнения Recv
+func (v *VkLongPoll) State() State {
+	v.stateMx.RLock()
+	defer v.stateMx.RUnlock()
+
+	return v.state
+}
+
+// Обновляет состояние соединения по результату запроса, вызывается под блокировкой mx
+func (v *VkLongPoll) updateState(res *Response, err error) {
+	state := State{
+		Ts:                v.Ts,
+		Pts:               v.pts,
+		Version:           v.version,
+		CredentialsSource: v.source,
+	}
+
+	if v.serverUrl != nil {
+		serverUrl := *v.serverUrl
+		serverUrl.RawQuery = ""
+		state.Server = serverUrl.String()
+	}
+
+	v.stateMx.Lock()
+	v.state = state
+	v.recordHealth(res, err)
+	v.stateMx.Unlock()
+}
@@ -0,0 +1,61 @@
+package vklongpoll
+
+import (
+	"sort"
+	"sync"
+)
+
+// Список подписчиков на изменения состояния (для трекеров событий)
+type subscribers[T any] struct {
+	fns    map[int]func(change T)
+	nextID int
+	mx     sync.Mutex
+}
+
+// Добавляет подписчика, возвращает функцию отписки
+func (s *subscribers[T]) add(fn func(change T)) (unsubscribe func()) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.fns == nil {
+		s.fns = map[int]func(change T){}
+	}
+
+	id := s.nextID
+	s.nextID++
+	s.fns[id] = fn
+
+	return func() {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		delete(s.fns, id)
+	}
+}
+
+// Уведомляет подписчиков в порядке подписки
+// Не должен вызываться под блокировкой трекера, чтобы подписчики могли читать его состояние
+func (s *subscribers[T]) notify(changes ...T) {
+	if len(changes) == 0 {
+		return
+	}
+
+	s.mx.Lock()
+	ids := make([]int, 0, len(s.fns))
+	for id := range s.fns {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	fns := make([]func(change T), len(ids))
+	for i, id := range ids {
+		fns[i] = s.fns[id]
+	}
+	s.mx.Unlock()
+
+	for _, change := range changes {
+		for _, fn := range fns {
+			fn(change)
+		}
+	}
+}
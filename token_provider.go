@@ -0,0 +1,200 @@
+package vklongpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Источник токенов доступа для запроса на получение Long Poll сервера
+type TokenProvider interface {
+	// Возвращает текущий токен
+	Token(ctx context.Context) (string, error)
+	// Сообщает, что токен отклонен ВКонтакте, следующий вызов Token должен вернуть другой токен, если он есть
+	Rotate(token string, reason error)
+}
+
+// Ошибка, возвращаемая, если у источника нет ни одного токена
+var ErrNoTokens = errors.New("no access tokens")
+
+// Список токенов с переключением на следующий после ошибки
+type tokenList struct {
+	tokens  []string
+	current int
+	mx      sync.Mutex
+}
+
+// Заменяет список токенов, сохраняя текущий токен, если он остался в списке
+func (t *tokenList) set(tokens []string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	current := ""
+	if t.current < len(t.tokens) {
+		current = t.tokens[t.current]
+	}
+
+	t.tokens = tokens
+	t.current = 0
+	for i, token := range tokens {
+		if token == current {
+			t.current = i
+		}
+	}
+}
+
+// Возвращает текущий токен
+func (t *tokenList) token() (string, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if len(t.tokens) == 0 {
+		return "", ErrNoTokens
+	}
+	return t.tokens[t.current], nil
+}
+
+// Переключается на следующий токен, если отклонен текущий
+func (t *tokenList) rotate(token string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if len(t.tokens) == 0 || t.tokens[t.current] != token {
+		return
+	}
+	t.current = (t.current + 1) % len(t.tokens)
+}
+
+// Разбивает строку на токены по запятым и переводам строк
+func splitTokens(s string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	}) {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Фиксированный список токенов
+type StaticTokens struct {
+	list tokenList
+}
+
+// Создает источник из фиксированного списка токенов
+func NewStaticTokens(tokens ...string) *StaticTokens {
+	s := &StaticTokens{}
+	s.list.set(tokens)
+	return s
+}
+
+// Возвращает текущий токен
+func (s *StaticTokens) Token(ctx context.Context) (string, error) {
+	return s.list.token()
+}
+
+// Переключается на следующий токен
+func (s *StaticTokens) Rotate(token string, reason error) {
+	s.list.rotate(token)
+}
+
+// Токены из переменной окружения (несколько токенов разделяются запятой)
+// Переменная перечитывается при каждом запросе токена
+type EnvTokens struct {
+	name string
+	last string
+	list tokenList
+	mx   sync.Mutex
+}
+
+// Создает источник токенов из переменной окружения
+func NewEnvTokens(name string) *EnvTokens {
+	return &EnvTokens{name: name}
+}
+
+// Возвращает текущий токен
+func (e *EnvTokens) Token(ctx context.Context) (string, error) {
+	e.mx.Lock()
+	value := os.Getenv(e.name)
+	if value != e.last {
+		e.last = value
+		e.list.set(splitTokens(value))
+	}
+	e.mx.Unlock()
+
+	token, err := e.list.token()
+	if err != nil {
+		return "", fmt.Errorf("env %s: %w", e.name, err)
+	}
+	return token, nil
+}
+
+// Переключается на следующий токен
+func (e *EnvTokens) Rotate(token string, reason error) {
+	e.list.rotate(token)
+}
+
+// Токены из файла (по одному на строку)
+// Файл перечитывается, если изменилось время его модификации, поэтому секреты можно менять без перезапуска
+type FileTokens struct {
+	path    string
+	modTime time.Time
+	size    int64
+	list    tokenList
+	mx      sync.Mutex
+}
+
+// Создает источник токенов из файла
+func NewFileTokens(path string) *FileTokens {
+	return &FileTokens{path: path}
+}
+
+// Возвращает текущий токен
+func (f *FileTokens) Token(ctx context.Context) (string, error) {
+	if err := f.reload(); err != nil {
+		return "", err
+	}
+
+	token, err := f.list.token()
+	if err != nil {
+		return "", fmt.Errorf("file %s: %w", f.path, err)
+	}
+	return token, nil
+}
+
+// Переключается на следующий токен
+func (f *FileTokens) Rotate(token string, reason error) {
+	f.list.rotate(token)
+}
+
+// Перечитывает файл, если он изменился
+func (f *FileTokens) reload() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("stat tokens file error: %w", err)
+	}
+
+	if stat.ModTime().Equal(f.modTime) && stat.Size() == f.size {
+		return nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("read tokens file error: %w", err)
+	}
+
+	f.modTime = stat.ModTime()
+	f.size = stat.Size()
+	f.list.set(splitTokens(string(content)))
+
+	return nil
+}
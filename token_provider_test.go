@@ -0,0 +1,160 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vkapiexecutor/response"
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestTokenServerUpdater(t *testing.T) {
+	var usedTokens []string
+	mx := sync.Mutex{}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		params, _ := url.ParseQuery(string(body))
+		token := params.Get("access_token")
+
+		mx.Lock()
+		usedTokens = append(usedTokens, token)
+		mx.Unlock()
+
+		if token != "good" {
+			w.Write([]byte(`{"error":{"error_code":5,"error_msg":"User authorization failed"}}`))
+			return
+		}
+
+		res, err := json.Marshal(getServerResponse("https://lp.vk.com/wh1"))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(res)
+	}))
+
+	defer apiServer.Close()
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	exec := executor.New()
+
+	t.Run("rotates rejected token", func(t *testing.T) {
+		usedTokens = nil
+		tokens := vklongpoll.NewStaticTokens("expired", "good")
+		creds, err := vklongpoll.TokenServerUpdater(request.New(), exec, tokens)(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if creds.Key != "longpoll_server_key" {
+			t.Errorf("expected key %q but got %q", "longpoll_server_key", creds.Key)
+		}
+
+		if len(usedTokens) != 2 || usedTokens[1] != "good" {
+			t.Errorf("expected tokens [expired good] but got %v", usedTokens)
+		}
+
+		token, _ := tokens.Token(context.Background())
+		if token != "good" {
+			t.Errorf("expected current token %q but got %q", "good", token)
+		}
+	})
+
+	t.Run("does not modify shared request", func(t *testing.T) {
+		req := request.New()
+		req.GetParams().Set("group_id", "1")
+
+		updater := vklongpoll.TokenServerUpdater(req, exec, vklongpoll.NewStaticTokens("good"))
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := updater(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if req.GetParams().GetAccessToken() != "" {
+			t.Errorf("shared request was modified: %s", req.GetParams())
+		}
+	})
+
+	t.Run("stops when all tokens rejected", func(t *testing.T) {
+		usedTokens = nil
+		tokens := vklongpoll.NewStaticTokens("expired", "banned")
+		_, err := vklongpoll.TokenServerUpdater(request.New(), exec, tokens)(context.Background())
+
+		var apiErr *response.Error
+		if !errors.As(err, &apiErr) || apiErr.IntCode() != 5 {
+			t.Errorf("expected wrapped api error but got %v", err)
+		}
+
+		if len(usedTokens) != 2 {
+			t.Errorf("expected 2 requests but got %d", len(usedTokens))
+		}
+	})
+}
+
+func TestTokenProviders(t *testing.T) {
+	t.Run("env tokens", func(t *testing.T) {
+		t.Setenv("VK_TEST_TOKENS", "a, b")
+		tokens := vklongpoll.NewEnvTokens("VK_TEST_TOKENS")
+
+		token, err := tokens.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "a" {
+			t.Errorf("expected token %q but got %q", "a", token)
+		}
+
+		tokens.Rotate("a", nil)
+		if token, _ := tokens.Token(context.Background()); token != "b" {
+			t.Errorf("expected token %q but got %q", "b", token)
+		}
+	})
+
+	t.Run("file tokens reloaded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens")
+		if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		tokens := vklongpoll.NewFileTokens(path)
+		if token, _ := tokens.Token(context.Background()); token != "first" {
+			t.Errorf("expected token %q but got %q", "first", token)
+		}
+
+		if err := os.WriteFile(path, []byte("second\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+
+		if token, _ := tokens.Token(context.Background()); token != "second" {
+			t.Errorf("expected token %q but got %q", "second", token)
+		}
+	})
+
+	t.Run("empty static tokens", func(t *testing.T) {
+		_, err := vklongpoll.NewStaticTokens().Token(context.Background())
+		if err != vklongpoll.ErrNoTokens {
+			t.Errorf("expected ErrNoTokens but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package vklongpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/ciricc/vkapiexecutor/executor"
+	"github.com/ciricc/vkapiexecutor/request"
+	"github.com/ciricc/vkapiexecutor/response"
+)
+
+// Коды ошибок VK API, после которых токен нужно заменить
+var DefaultTokenRotationCodes = []int{
+	5,  // Авторизация пользователя не удалась
+	6,  // Слишком много запросов в секунду
+	29, // Достигнут количественный лимит на вызов метода
+}
+
+// То же самое, что UniversalServerUpdater, но токен доступа берется из TokenProvider
+// Если ВКонтакте отклоняет токен (см. DefaultTokenRotationCodes), то запрос повторяется со следующим токеном,
+// пока токены не начнут повторяться. Тогда возвращается ошибка, оборачивающая последнюю ошибку VK API.
+// Для каждого вызова создается копия req, поэтому обработчик можно использовать в нескольких соединениях
+func TokenServerUpdater(req *request.Request, exec *executor.Executor, tokens TokenProvider) ServerUpdater {
+	mx := sync.Mutex{}
+	return func(ctx context.Context) (*ServerCredentials, error) {
+		tried := map[string]bool{}
+		var lastErr error
+		for {
+			token, err := tokens.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if tried[token] {
+				return nil, fmt.Errorf("all access tokens rejected: %w", lastErr)
+			}
+			tried[token] = true
+
+			// Params.String может изменять параметры (RemoveBlanks), поэтому копирование под блокировкой
+			mx.Lock()
+			tokenReq, err := cloneRequest(req)
+			mx.Unlock()
+			if err != nil {
+				return nil, err
+			}
+
+			tokenReq.GetParams().AccessToken(token)
+			creds, err := UniversalServerUpdater(tokenReq, exec)(ctx)
+			if err == nil || !isTokenRotationError(err) {
+				return creds, err
+			}
+
+			lastErr = err
+			tokens.Rotate(token, err)
+		}
+	}
+}
+
+// Копирует метод, параметры и заголовки запроса
+func cloneRequest(req *request.Request) (*request.Request, error) {
+	values, err := url.ParseQuery(req.GetParams().String())
+	if err != nil {
+		return nil, err
+	}
+
+	params := request.NewParamsFromUrl(values)
+	params.RemoveBlanks = req.GetParams().RemoveBlanks
+
+	clone := request.New()
+	clone.Method(req.GetMethod())
+	clone.Params(params)
+	clone.AppendHeaders(req.GetHeaders())
+
+	return clone, nil
+}
+
+// Проверяет, является ли ошибка причиной для замены токена
+func isTokenRotationError(err error) bool {
+	var apiErr *response.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, code := range DefaultTokenRotationCodes {
+		if apiErr.IntCode() == code {
+			return true
+		}
+	}
+
+	return false
+}
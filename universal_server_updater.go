@@ -53,6 +53,10 @@ func UniversalServerUpdater(req *request.Request, exec *executor.Executor) Serve
 			return nil, err
 		}
 
+		if pts, err := jsonparser.GetInt(res, "pts"); err == nil {
+			creds.Pts = (*Pts)(&pts)
+		}
+
 		return &creds, nil
 	}
 }
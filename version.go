@@ -0,0 +1,73 @@
+package vklongpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Ошибка, возвращаемая при failed=4 (неподдерживаемая версия Long Poll)
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Возвращается из recvResponse, когда версия согласована и запрос нужно повторить
+var errVersionNegotiated = errors.New("long poll version negotiated")
+
+// Согласованная версия Long Poll
+type versionNegotiation struct {
+	requested int // Версия, которую указал пользователь
+	version   int // Версия, которую поддерживает сервер
+}
+
+// Возвращает опции с согласованной версией, если она есть для запрошенной версии
+func (v *VkLongPoll) versionOptions(opt *VkLongPollOptions) *VkLongPollOptions {
+	if !opt.NegotiateVersion || v.negotiated.version == 0 || v.negotiated.requested != opt.Version {
+		return opt
+	}
+
+	negotiatedOpt := *opt
+	negotiatedOpt.Version = v.negotiated.version
+
+	return &negotiatedOpt
+}
+
+// Выбирает наибольшую поддерживаемую сервером версию, не превышающую запрошенную,
+// и обновляет данные сервера с этой версией
+func (v *VkLongPoll) negotiateVersion(ctx context.Context, opt *VkLongPollOptions, requested int, res *pollResponse) error {
+	version := requested
+	if res.maxVersion > 0 && int64(version) > res.maxVersion {
+		version = int(res.maxVersion)
+	}
+
+	if int64(version) < res.minVersion || version == opt.Version {
+		return fmt.Errorf("%w: requested %d, supported %d-%d", ErrInvalidVersion, requested, res.minVersion, res.maxVersion)
+	}
+
+	v.negotiated = versionNegotiation{
+		requested: requested,
+		version:   version,
+	}
+
+	negotiatedOpt := *opt
+	negotiatedOpt.Version = version
+
+	currentTs := v.Ts
+	hadServer := v.serverUrl != nil
+
+	_, err := v.updateServer(ctx, &negotiatedOpt, &ServerCredentials{
+		Ts:        v.Ts,
+		ServerURL: v.serverUrl,
+		Key:       v.key,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Версия меняется посреди работы: продолжаем с текущего ts, чтобы не пропустить события
+	if hadServer {
+		v.Ts = currentTs
+	}
+
+	opt.Observer.versionNegotiated(ctx, requested, version)
+
+	return nil
+}
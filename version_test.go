@@ -0,0 +1,130 @@
+package vklongpoll_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ciricc/vklongpoll"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	var pollVersions []string
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.URL.Query().Get("version")
+		pollVersions = append(pollVersions, version)
+		if version != "1" && version != "2" {
+			w.Write([]byte(`{"failed":4,"min_version":1,"max_version":2}`))
+			return
+		}
+		w.Write([]byte(`{"ts":2,"updates":[]}`))
+	}))
+
+	defer longPollServer.Close()
+
+	var updaterVersions []int
+	serverUpdater := vklongpoll.WithServerUpdater(func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		updaterVersions = append(updaterVersions, vklongpoll.OptionsFromContext(ctx).Version)
+		serverUrl, _ := url.Parse(longPollServer.URL)
+		return &vklongpoll.ServerCredentials{Ts: 1, ServerURL: serverUrl, Key: "key"}, nil
+	})
+
+	t.Run("invalid version without negotiation", func(t *testing.T) {
+		_, err := vklongpoll.New().Recv(context.Background(), serverUpdater, vklongpoll.WithVersion(3))
+		if !errors.Is(err, vklongpoll.ErrInvalidVersion) {
+			t.Errorf("expected ErrInvalidVersion but got %v", err)
+		}
+	})
+
+	t.Run("negotiates highest supported version", func(t *testing.T) {
+		pollVersions, updaterVersions = nil, nil
+
+		var negotiated int
+		observer := &vklongpoll.Observer{
+			VersionNegotiated: func(ctx context.Context, requested int, version int) {
+				negotiated = version
+			},
+		}
+
+		lp := vklongpoll.New()
+		opt := vklongpoll.BuildOptions(
+			serverUpdater,
+			vklongpoll.WithVersion(3),
+			vklongpoll.WithVersionNegotiation(true),
+			vklongpoll.WithObserver(observer),
+		)
+
+		for i := 0; i < 2; i++ {
+			if _, err := lp.RecvOpt(context.Background(), opt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expectedPollVersions := []string{"3", "2", "2"}
+		if !reflect.DeepEqual(pollVersions, expectedPollVersions) {
+			t.Errorf("expected poll versions %v but got %v", expectedPollVersions, pollVersions)
+		}
+
+		expectedUpdaterVersions := []int{3, 2}
+		if !reflect.DeepEqual(updaterVersions, expectedUpdaterVersions) {
+			t.Errorf("expected server updater versions %v but got %v", expectedUpdaterVersions, updaterVersions)
+		}
+
+		if negotiated != 2 || lp.State().Version != 2 {
+			t.Errorf("expected negotiated version 2 but got %d (state %d)", negotiated, lp.State().Version)
+		}
+	})
+
+	t.Run("no supported version", func(t *testing.T) {
+		_, err := vklongpoll.New().Recv(context.Background(),
+			serverUpdater,
+			vklongpoll.WithVersion(0),
+			vklongpoll.WithVersionNegotiation(true),
+		)
+		if !errors.Is(err, vklongpoll.ErrInvalidVersion) {
+			t.Errorf("expected ErrInvalidVersion but got %v", err)
+		}
+	})
+}
+
+func TestVersionNegotiationKeepsTs(t *testing.T) {
+	var pollTs []string
+	supported := "3"
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pollTs = append(pollTs, r.URL.Query().Get("ts"))
+		if r.URL.Query().Get("version") != supported {
+			w.Write([]byte(`{"failed":4,"min_version":1,"max_version":2}`))
+			return
+		}
+		w.Write([]byte(`{"ts":5,"updates":[]}`))
+	}))
+
+	defer longPollServer.Close()
+
+	serverUpdater := vklongpoll.WithServerUpdater(func(ctx context.Context) (*vklongpoll.ServerCredentials, error) {
+		serverUrl, _ := url.Parse(longPollServer.URL)
+		return &vklongpoll.ServerCredentials{Ts: 1, ServerURL: serverUrl, Key: "key"}, nil
+	})
+
+	lp := vklongpoll.New()
+	opt := vklongpoll.BuildOptions(serverUpdater, vklongpoll.WithVersion(3), vklongpoll.WithVersionNegotiation(true))
+
+	if _, err := lp.RecvOpt(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сервер перестает поддерживать версию 3 после того, как ts продвинулся
+	supported = "2"
+	if _, err := lp.RecvOpt(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTs := []string{"1", "5", "5"}
+	if !reflect.DeepEqual(pollTs, expectedTs) {
+		t.Errorf("expected poll ts %v but got %v", expectedTs, pollTs)
+	}
+}
@@ -1,14 +1,17 @@
 package vklongpoll
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/buger/jsonparser"
 )
@@ -20,11 +23,60 @@ type VkLongPoll struct {
 	Ts         int64
 	pts        *Pts
 	mx         *sync.Mutex
+	buf        *bytes.Buffer      // Буфер последнего ответа в режиме ZeroCopy
+	bufMx      sync.Mutex         // Защищает buf: Response.Release вызывается без ожидания Recv
+	source     string             // Источник текущих данных сервера (ServerCredentials.Source)
+	version    int                // Версия Long Poll последнего запроса
+	negotiated versionNegotiation // Согласованная версия Long Poll
+	credsValid bool               // Не отклонены ли текущие данные сервера
+	health     Health             // Состояние здоровья соединения
+	closed     bool               // Закрыто ли соединение
+	cancelPoll func()             // Отменяет текущий запрос
+	handlers   sync.WaitGroup     // Обработчики, запущенные через Go
+	closers    []io.Closer        // Ресурсы, которые закрывает Close
+	closeMx    sync.Mutex
+	control    control // Отложенные команды управления (Pause, SetTs и др.)
+	state      State   // Состояние соединения, доступное без ожидания текущего запроса
+	stateMx    sync.RWMutex
 }
 
 type Pts int64
 type Update []byte
 
+// Полный ответ Long Poll сервера
+type Response struct {
+	PrevTs     int64         // Значение ts, с которым был сделан запрос
+	Ts         int64         // Новое значение ts
+	Pts        *Pts          // Значение pts, если оно есть в ответе
+	Failed     int64         // Значение поля failed (1, 2, 3 - ts или данные сервера обновлены, событий нет)
+	Body       []byte        // Тело ответа (в режиме ZeroCopy действительно до Release или следующего вызова Recv)
+	StatusCode int           // HTTP статус ответа
+	Header     http.Header   // HTTP заголовки ответа
+	Duration   time.Duration // Длительность запроса
+	Proxy      string        // Имя прокси из ProxyPool, через который был отправлен запрос
+	Updates    []Update      // Полученные события
+	lp         *VkLongPoll
+	buf        *bytes.Buffer // Буфер ответа в режиме ZeroCopy
+}
+
+// Возвращает буфер ответа в пул (в режиме ZeroCopy), вызывайте после обработки событий
+// После вызова Body и Updates использовать нельзя. Если Release не вызван,
+// буфер возвращается в пул при следующем вызове Recv или при Close
+func (r *Response) Release() {
+	if r == nil || r.buf == nil {
+		return
+	}
+
+	r.lp.bufMx.Lock()
+	defer r.lp.bufMx.Unlock()
+
+	if r.lp.buf == r.buf {
+		putResponseBuffer(r.buf)
+		r.lp.buf = nil
+	}
+	r.buf = nil
+}
+
 // Создает инстанс лонгполла
 // Принимает executor - структура для отправки запросов к VK API
 func New() *VkLongPoll {
@@ -55,21 +107,86 @@ func (v *VkLongPoll) Recv(ctx context.Context, opts ...VkLongPollOption) ([]Upda
 
 // То же самое, что Recv, но опции - ссылка на структуру
 func (v *VkLongPoll) RecvOpt(ctx context.Context, opt *VkLongPollOptions) ([]Update, error) {
-	v.mx.Lock()
+	res, err := v.RecvResponseOpt(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	return res.Updates, nil
+}
+
+// То же самое, что Recv, но возвращает полный ответ Long Poll сервера
+func (v *VkLongPoll) RecvResponse(ctx context.Context, opts ...VkLongPollOption) (*Response, error) {
+	return v.RecvResponseOpt(ctx, BuildOptions(opts...))
+}
+
+// То же самое, что RecvResponse, но опции - ссылка на структуру
+func (v *VkLongPoll) RecvResponseOpt(ctx context.Context, opt *VkLongPollOptions) (res *Response, err error) {
+	for {
+		if err := v.waitResume(ctx); err != nil {
+			return nil, err
+		}
+
+		v.mx.Lock()
+		// Pause мог быть вызван, пока ждали завершения другого Recv
+		if !v.Paused() {
+			break
+		}
+		v.mx.Unlock()
+	}
 	defer v.mx.Unlock()
 
+	ctx, err = v.beginPoll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer v.endPoll()
+	defer func() {
+		v.updateState(res, err)
+	}()
+
 	if opt.ServerUpdater == nil {
 		return nil, fmt.Errorf("server updater is nil")
 	}
 
+	if err := opt.Mode.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := v.applyControl(ctx, v.versionOptions(opt)); err != nil {
+		return nil, err
+	}
+
+	res, err = v.recvResponse(ctx, v.versionOptions(opt), opt.Version)
+	if err == errVersionNegotiated {
+		res, err = v.recvResponse(ctx, v.versionOptions(opt), opt.Version)
+	}
+
+	if err == errVersionNegotiated {
+		return nil, fmt.Errorf("%w: negotiated version %d rejected", ErrInvalidVersion, v.version)
+	}
+
+	if err != nil && v.isClosed() {
+		return nil, ErrClosed
+	}
+
+	return res, err
+}
+
+// Выполняет один запрос a_check
+// requested - версия Long Poll, которую указал пользователь (opt.Version может быть согласованной версией)
+func (v *VkLongPoll) recvResponse(ctx context.Context, opt *VkLongPollOptions, requested int) (pollResult *Response, err error) {
+	v.releaseBuffer()
+	v.version = opt.Version
+
 	if v.serverUrl == nil {
-		err := v.updateServer(ctx, opt)
+		_, err := v.updateServer(ctx, opt, nil)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	requestUrl := v.serverUrl
+	requestUrl := *v.serverUrl
 	requestUrlQuery := requestUrl.Query()
 
 	requestUrlQuery.Set("key", v.key)
@@ -82,94 +199,214 @@ func (v *VkLongPoll) RecvOpt(ctx context.Context, opt *VkLongPollOptions) ([]Upd
 		requestUrlQuery.Set("mode", strconv.Itoa(int(opt.Mode)))
 	}
 
+	for key, values := range opt.Params {
+		requestUrlQuery[key] = values
+	}
+
 	if opt.ParamsMerger != nil {
 		opt.ParamsMerger(requestUrlQuery)
 	}
 
 	requestUrl.RawQuery = requestUrlQuery.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl.String(), nil)
+	info := PollInfo{Ts: v.Ts, Host: requestUrl.Host}
+	ctx = opt.Observer.pollStart(ctx, info)
+	defer func() {
+		info.Ts = v.Ts
+		if pollResult != nil {
+			info.Updates = len(pollResult.Updates)
+		}
+		info.Err = err
+		opt.Observer.pollDone(ctx, info)
+	}()
+
+	pollResult = &Response{PrevTs: v.Ts}
+	requestStart := time.Now()
+
+	reqCtx, servedBy := withServedBy(ctx)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", requestUrl.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	res, err := v.HttpClient.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("poll request error: %w", ctx.Err())
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("poll request error: %s; requestUrl=%s", err.Error(), requestUrl)
+		return nil, fmt.Errorf("poll request error: %s; requestUrl=%s", v.redactKey(err.Error()), v.redactKey(requestUrl.String()))
 	}
 
 	defer res.Body.Close()
 
-	resBytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response error: %s", err.Error())
+	pollResult.Proxy = *servedBy
+	info.Proxy = *servedBy
+	pollResult.StatusCode = res.StatusCode
+	pollResult.Header = res.Header
+
+	buf := getResponseBuffer()
+	if opt.ZeroCopy {
+		v.bufMx.Lock()
+		v.buf = buf
+		v.bufMx.Unlock()
+		pollResult.lp, pollResult.buf = v, buf
+	} else {
+		defer putResponseBuffer(buf)
 	}
 
-	failed, _ := jsonparser.GetInt(resBytes, "failed")
+	err = readResponse(buf, res.Body, opt.MaxResponseBytes)
+	if err != nil {
+		return nil, fmt.Errorf("read response error: %w", err)
+	}
 
-	ptsInt, err := jsonparser.GetInt(resBytes, "pts")
-	if err == nil {
-		v.pts = (*Pts)(&ptsInt)
-	} else {
-		ptsStr, _ := jsonparser.GetString(resBytes, "pts")
-		ptsInt, err := strconv.ParseInt(ptsStr, 10, 64)
-		if err == nil {
-			v.pts = (*Pts)(&ptsInt)
-		} else {
-			v.pts = nil
-		}
+	pollResult.Duration = time.Since(requestStart)
+	pollResult.Body = buf.Bytes()
+	if !opt.ZeroCopy {
+		pollResult.Body = append([]byte(nil), pollResult.Body...)
 	}
 
-	if failed != 0 {
-		switch failed {
+	pollRes := parsePollResponse(pollResult.Body, opt.UpdatesJsonPath)
+	info.Failed = pollRes.failed
+	pollResult.Failed = pollRes.failed
+	pollResult.Pts = pollRes.pts
+	v.pts = pollRes.pts
+
+	if pollRes.failed != 0 {
+		switch pollRes.failed {
 		case 2, 3:
-			err = v.updateServer(ctx, opt)
+			currentTs := v.Ts
+			v.credsValid = false
+			_, err = v.updateServer(ctx, opt, &ServerCredentials{
+				Ts:        v.Ts,
+				ServerURL: v.serverUrl,
+				Key:       v.key,
+			})
 			if err != nil {
 				return nil, err
 			}
+
+			// failed=2: истек только ключ, ts остается прежним, иначе события до нового ts потеряются
+			// failed=3: информация утеряна, продолжаем с ts из новых данных сервера
+			if pollRes.failed == 2 {
+				v.Ts = currentTs
+			}
+
+			// Данные сервера обновлены, событий в этом ответе нет
+			pollResult.Ts = v.Ts
+			pollResult.Updates = []Update{}
+			return pollResult, nil
 		case 4:
-			return nil, fmt.Errorf("invalid version")
+			if !opt.NegotiateVersion {
+				return nil, fmt.Errorf("%w: requested %d, supported %d-%d", ErrInvalidVersion, opt.Version, pollRes.minVersion, pollRes.maxVersion)
+			}
+
+			err = v.negotiateVersion(ctx, opt, requested, &pollRes)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, errVersionNegotiated
 		}
 	}
 
-	v.Ts, err = getTs(resBytes)
+	ts, err := pollRes.getTs()
 
 	if err != nil {
 		return nil, err
 	}
 
-	updatesBytes, _, _, err := jsonparser.Get(resBytes, opt.UpdatesJsonPath...)
-	if err != nil {
-		return nil, err
+	if !pollRes.hasUpdates {
+		v.Ts = ts
+		if pollRes.failed == 0 {
+			return nil, jsonparser.KeyPathNotFoundError
+		}
+
+		// failed=1: история событий устарела, продолжаем с нового ts
+		pollResult.Ts = ts
+		pollResult.Updates = []Update{}
+		return pollResult, nil
 	}
 
-	updates := []Update{}
+	pollResult.Ts = ts
+	pollResult.Updates = pollRes.getUpdates()
+
+	if len(pollResult.Updates) != 0 && len(opt.Sinks) != 0 {
+		err = v.writeSinks(ctx, opt, &Batch{
+			ReceivedAt: time.Now(),
+			Source:     v.source,
+			PrevTs:     pollResult.PrevTs,
+			Ts:         ts,
+			Updates:    pollResult.Updates,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	jsonparser.ArrayEach(updatesBytes, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		if dataType == jsonparser.String { // ну вдруг ))
-			updates = append(updates, []byte(`"`+string(value)+`"`))
-		} else {
-			updates = append(updates, value)
+	v.Ts = ts
+	if opt.Filter != nil {
+		if len(opt.Sinks) != 0 {
+			// Получатели могут хранить Batch.Updates, поэтому фильтруем копию
+			pollResult.Updates = append([]Update(nil), pollResult.Updates...)
 		}
-	})
+		pollResult.Updates = opt.Filter.Apply(pollResult.Updates)
+	}
 
-	return updates, nil
+	return pollResult, nil
+}
+
+// Скрывает ключ сервера в тексте ошибки, чтобы он не попал в логи и HealthHandler
+func (v *VkLongPoll) redactKey(s string) string {
+	if v.key == "" {
+		return s
+	}
+	s = strings.ReplaceAll(s, url.QueryEscape(v.key), "REDACTED")
+	return strings.ReplaceAll(s, v.key, "REDACTED")
+}
+
+// Возвращает в пул буфер предыдущего ответа, если включен режим ZeroCopy и он еще не возвращен через Release
+func (v *VkLongPoll) releaseBuffer() {
+	v.bufMx.Lock()
+	defer v.bufMx.Unlock()
+
+	if v.buf != nil {
+		putResponseBuffer(v.buf)
+		v.buf = nil
+	}
 }
 
 // Обновляет настройки Long Poll соединения
-func (v *VkLongPoll) updateServer(ctx context.Context, opt *VkLongPollOptions) error {
+// rejected - данные сервера, которые отклонил Long Poll сервер (nil при первом подключении)
+// Возвращает полученные данные сервера
+func (v *VkLongPoll) updateServer(ctx context.Context, opt *VkLongPollOptions, rejected *ServerCredentials) (*ServerCredentials, error) {
 	if opt.ServerUpdater == nil {
-		return errors.New("server updater is nil")
+		return nil, errors.New("server updater is nil")
 	}
 
+	ctx = withOptions(ctx, opt)
+	if rejected != nil {
+		ctx = withRejectedCredentials(ctx, rejected)
+	}
+
+	ctx = opt.Observer.serverUpdateStart(ctx)
 	creds, err := opt.ServerUpdater(ctx)
+	opt.Observer.serverUpdateDone(ctx, creds, err)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	v.credsValid = true
 	v.key = creds.Key
 	v.serverUrl = creds.ServerURL
-	v.Ts = creds.Ts
+	// ts из кеша мог устареть, пока соединение получало события
+	if !creds.Cached || creds.Ts > v.Ts {
+		v.Ts = creds.Ts
+	}
+	v.source = creds.Source
+	if creds.Pts != nil {
+		v.pts = creds.Pts
+	}
 
-	return nil
+	return creds, nil
 }
@@ -3,6 +3,7 @@ package vklongpoll_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -345,3 +346,310 @@ func TestLongPollParams(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestLongPollObserver(t *testing.T) {
+	lpServerResponse := &LongPollServerResponse{
+		Ts:      "5",
+		Updates: []interface{}{1, 2},
+	}
+
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := json.Marshal(lpServerResponse)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(res)
+	}))
+
+	defer longPollServer.Close()
+
+	expectedGetServerResponse := getServerResponse(longPollServer.URL)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := json.Marshal(expectedGetServerResponse)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(res)
+	}))
+
+	defer apiServer.Close()
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	exec := executor.New()
+
+	var started, serverUpdates int
+	var done vklongpoll.PollInfo
+
+	observer := &vklongpoll.Observer{
+		PollStart: func(ctx context.Context, info vklongpoll.PollInfo) context.Context {
+			started++
+			if info.Ts != 1 {
+				t.Errorf("expected start ts %d but got %d", 1, info.Ts)
+			}
+			return ctx
+		},
+		PollDone: func(ctx context.Context, info vklongpoll.PollInfo) {
+			done = info
+		},
+		ServerUpdateDone: func(ctx context.Context, creds *vklongpoll.ServerCredentials, err error) {
+			serverUpdates++
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	}
+
+	lp := vklongpoll.New()
+	_, err := lp.Recv(context.Background(),
+		vklongpoll.WithServerUpdater(vklongpoll.UniversalServerUpdater(request.New(), exec)),
+		vklongpoll.WithObserver(observer),
+	)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if started != 1 || serverUpdates != 1 {
+		t.Errorf("expected one poll and one server update but got %d and %d", started, serverUpdates)
+	}
+
+	expectedHost, _ := url.Parse(longPollServer.URL)
+	expectedDone := vklongpoll.PollInfo{Ts: 5, Host: expectedHost.Host, Updates: 2}
+	if !reflect.DeepEqual(done, expectedDone) {
+		t.Errorf("expected done info %v but got %v", expectedDone, done)
+	}
+}
+
+// Запускает Long Poll сервер с указанным телом ответа и API сервер, который возвращает его адрес
+func startLongPollServers(tb testing.TB, body []byte) (serverUpdater vklongpoll.VkLongPollOption, closeServers func()) {
+	longPollServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+
+	getServerResponse, err := json.Marshal(getServerResponse(longPollServer.URL))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(getServerResponse)
+	}))
+
+	request.DefaultBaseRequestUrl = apiServer.URL
+	serverUpdater = vklongpoll.WithServerUpdater(
+		vklongpoll.UniversalServerUpdater(request.New(), executor.New()),
+	)
+
+	return serverUpdater, func() {
+		longPollServer.Close()
+		apiServer.Close()
+	}
+}
+
+// Возвращает ответ Long Poll сервера с n событиями
+func longPollBatch(tb testing.TB, n int) []byte {
+	updates := make([]interface{}, n)
+	for i := range updates {
+		updates[i] = map[string]interface{}{
+			"type":     "message_new",
+			"event_id": strconv.Itoa(i),
+			"object": map[string]interface{}{
+				"message": map[string]interface{}{
+					"id":   i,
+					"text": "Hello, world! This is a long poll benchmark message",
+				},
+			},
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"ts":      "2",
+		"pts":     10,
+		"updates": updates,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return body
+}
+
+func TestLongPollResponseReading(t *testing.T) {
+	body := longPollBatch(t, 10)
+	serverUpdater, closeServers := startLongPollServers(t, body)
+	defer closeServers()
+
+	t.Run("too large response", func(t *testing.T) {
+		lp := vklongpoll.New()
+		_, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithMaxResponseBytes(int64(len(body)-1)))
+		if !errors.Is(err, vklongpoll.ErrResponseTooLarge) {
+			t.Errorf("expected ErrResponseTooLarge but got %v", err)
+		}
+	})
+
+	t.Run("response in limit", func(t *testing.T) {
+		lp := vklongpoll.New()
+		updates, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithMaxResponseBytes(int64(len(body))))
+		if err != nil {
+			t.Error(err)
+		}
+		if len(updates) != 10 {
+			t.Errorf("expected 10 updates but got %d", len(updates))
+		}
+		if lp.Pts() == nil || *lp.Pts() != 10 {
+			t.Errorf("expected pts 10 but got %v", lp.Pts())
+		}
+	})
+
+	t.Run("invalid mode rejected", func(t *testing.T) {
+		lp := vklongpoll.New()
+		_, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithMode(4))
+		if err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+
+	t.Run("zero copy updates same as copied", func(t *testing.T) {
+		lp := vklongpoll.New()
+		copied, err := lp.Recv(context.Background(), serverUpdater)
+		if err != nil {
+			t.Error(err)
+		}
+
+		zeroCopy, err := lp.Recv(context.Background(), serverUpdater, vklongpoll.WithZeroCopy(true))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !reflect.DeepEqual(copied, zeroCopy) {
+			t.Errorf("expected zero copy updates %s but got %s", copied, zeroCopy)
+		}
+	})
+
+	t.Run("zero copy release", func(t *testing.T) {
+		lp := vklongpoll.New()
+		first, err := lp.RecvResponse(context.Background(), serverUpdater, vklongpoll.WithZeroCopy(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := lp.RecvResponse(context.Background(), serverUpdater, vklongpoll.WithZeroCopy(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := append([]byte(nil), second.Body...)
+
+		// Буфер первого ответа уже возвращен при втором Recv, повторно он не освобождается
+		first.Release()
+		first.Release()
+
+		if string(second.Body) != string(body) {
+			t.Errorf("second response changed after releasing the first one")
+		}
+
+		second.Release()
+
+		if err := lp.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkRecv(b *testing.B) {
+	serverUpdater, closeServers := startLongPollServers(b, longPollBatch(b, 500))
+	defer closeServers()
+
+	benchmarks := []struct {
+		name string
+		opts []vklongpoll.VkLongPollOption
+	}{
+		{"copy", []vklongpoll.VkLongPollOption{serverUpdater}},
+		{"zero copy", []vklongpoll.VkLongPollOption{serverUpdater, vklongpoll.WithZeroCopy(true)}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			lp := vklongpoll.New()
+			opt := vklongpoll.BuildOptions(bm.opts...)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := lp.RecvOpt(context.Background(), opt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecvResponse(t *testing.T) {
+	body := []byte(`{"ts":"7","pts":3,"updates":["Hi \"there\" Ж",{"type":"message_new"},"",[4,1]]}`)
+	serverUpdater, closeServers := startLongPollServers(t, body)
+	defer closeServers()
+
+	lp := vklongpoll.New()
+	res, err := lp.RecvResponse(context.Background(), serverUpdater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.PrevTs != 1 || res.Ts != 7 {
+		t.Errorf("expected ts 1 -> 7 but got %d -> %d", res.PrevTs, res.Ts)
+	}
+
+	if res.Pts == nil || *res.Pts != 3 {
+		t.Errorf("expected pts 3 but got %v", res.Pts)
+	}
+
+	if res.StatusCode != http.StatusOK || res.Header == nil {
+		t.Errorf("expected http response info but got status %d and headers %v", res.StatusCode, res.Header)
+	}
+
+	if string(res.Body) != string(body) {
+		t.Errorf("expected body %s but got %s", body, res.Body)
+	}
+
+	expectedUpdates := []string{`"Hi \"there\" Ж"`, `{"type":"message_new"}`, `""`, `[4,1]`}
+	updates := make([]string, len(res.Updates))
+	for i, update := range res.Updates {
+		updates[i] = string(update)
+	}
+
+	if !reflect.DeepEqual(updates, expectedUpdates) {
+		t.Errorf("expected updates %v but got %v", expectedUpdates, updates)
+	}
+}
+
+func TestRecvResponseFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		failed int64
+		ts     int64
+	}{
+		{"history outdated", `{"failed":1,"ts":30}`, 1, 30},
+		{"key expired", `{"failed":2}`, 2, 10},
+		{"info lost", `{"failed":3}`, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverUpdater, closeServers := startLongPollServers(t, []byte(tt.body))
+			defer closeServers()
+
+			// Соединение уже продвинулось дальше ts из данных сервера (1)
+			lp := vklongpoll.New()
+			lp.SetTs(10)
+
+			res, err := lp.RecvResponse(context.Background(), serverUpdater)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Failed != tt.failed || res.Ts != tt.ts || lp.Ts != tt.ts || len(res.Updates) != 0 {
+				t.Errorf("unexpected response: failed %d, ts %d, lp ts %d, %d updates", res.Failed, res.Ts, lp.Ts, len(res.Updates))
+			}
+		})
+	}
+}